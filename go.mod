@@ -2,7 +2,4 @@ module github.com/karelbilek/wazero-fs-tools
 
 go 1.22.0
 
-require (
-	github.com/blang/vfs v1.0.0
-	github.com/tetratelabs/wazero v1.6.0
-)
+require github.com/tetratelabs/wazero v1.6.0
@@ -0,0 +1,116 @@
+package memfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"runtime"
+	"sync"
+
+	wasys "github.com/tetratelabs/wazero/sys"
+)
+
+// BlobStore holds file contents by their hash, so that identical data of
+// different files, in one MemFS or in many, is stored once; see
+// WithBlobStore. It is safe for concurrent use.
+//
+// Contents are stored by chunk (4 KiB), so identical files share all their
+// memory, and files differing in a few places most of it. Shared data is
+// never changed: a write to a deduplicated part of a file copies it first.
+type BlobStore struct {
+	mu    sync.Mutex
+	blobs map[[sha256.Size]byte]*blob
+}
+
+// blob is data held by a BlobStore, with the count of chunks using it.
+type blob struct {
+	data []byte
+	refs int
+}
+
+// NewBlobStore returns an empty BlobStore.
+func NewBlobStore() *BlobStore {
+	return &BlobStore{blobs: map[[sha256.Size]byte]*blob{}}
+}
+
+// BlobStats are the figures of a BlobStore; see Stats.
+type BlobStats struct {
+	// Blobs is the number of distinct chunks held.
+	Blobs int
+	// Bytes is the size of the data held.
+	Bytes int64
+	// Referenced is the size of the file data using the store, as it would
+	// be without deduplication.
+	Referenced int64
+}
+
+// Saved returns the memory saved by deduplication.
+func (st BlobStats) Saved() int64 {
+	return st.Referenced - st.Bytes
+}
+
+// Stats returns the current figures of s. Data of removed or overwritten
+// files is only given back once the garbage collector has found it unused,
+// so the figures lag behind until then.
+func (s *BlobStore) Stats() BlobStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var st BlobStats
+	for _, b := range s.blobs {
+		st.Blobs++
+		st.Bytes += int64(len(b.data))
+		st.Referenced += int64(b.refs * len(b.data))
+	}
+	return st
+}
+
+// chunk returns a chunk of the blob holding data, which is added if missing.
+// The chunk belongs to no inode, so it is copied before any write, and the
+// blob is removed once no chunk uses it anymore.
+func (s *BlobStore) chunk(data []byte) *chunk {
+	sum := sha256.Sum256(data)
+
+	s.mu.Lock()
+	b := s.blobs[sum]
+	if b == nil {
+		b = &blob{data: bytes.Clone(data)}
+		s.blobs[sum] = b
+	}
+	b.refs++
+	s.mu.Unlock()
+
+	c := &chunk{data: b.data}
+	runtime.SetFinalizer(c, func(*chunk) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if b.refs--; b.refs == 0 {
+			delete(s.blobs, sum)
+		}
+	})
+	return c
+}
+
+// dedup replaces the chunks held in memory that n wrote itself by the ones
+// of s with the same data; n.mu must be held. Chunks already shared are left
+// as they are.
+func (n *inode) dedup(s *BlobStore) {
+	for idx, c := range n.chunks {
+		if c.gen != n.gen || c.b != nil || len(c.data) == 0 {
+			continue
+		}
+		// chunks of n's own are only in a chunks map of its own
+		n.chunks[idx] = s.chunk(c.data)
+	}
+}
+
+// dedup moves the content of the file ino, just written through a file
+// being closed, to the BlobStore of m, if any.
+func (m *MemFS) dedup(ino wasys.Inode) {
+	if m.blobs == nil {
+		return
+	}
+	if n := m.mut(ino); n != nil {
+		n.mu.Lock()
+		n.dedup(m.blobs)
+		n.mu.Unlock()
+	}
+}
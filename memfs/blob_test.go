@@ -0,0 +1,94 @@
+package memfs
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/tetratelabs/wazero/experimental/sys"
+)
+
+func TestBlobStore(t *testing.T) {
+	s := NewBlobStore()
+	// three identical chunks, then a shorter one
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1000)
+	var ms []*MemFS
+	for i := 0; i < 5; i++ {
+		m := New(WithBlobStore(s))
+		for _, p := range []string{"lib/a", "lib/b"} {
+			if errno := m.WriteFile(p, content, 0o644); errno != 0 {
+				t.Fatal(errno)
+			}
+		}
+		ms = append(ms, m)
+	}
+	want := BlobStats{Blobs: 2, Bytes: chunkSize + 3712, Referenced: 10 * 16000}
+	if st := s.Stats(); st != want {
+		t.Errorf("stats are %+v, want %+v", st, want)
+	}
+	if u := ms[0].Usage(); u.Bytes != 2*16000 {
+		t.Errorf("usage is %+v, not counted in full", u)
+	}
+
+	// shared chunks are copied before a write
+	f, errno := ms[0].OpenFile("lib/a", sys.O_RDWR, 0)
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	if _, errno := f.Pwrite([]byte("XX"), 5000); errno != 0 {
+		t.Fatal(errno)
+	}
+	f.Close()
+	for _, c := range []struct {
+		m       *MemFS
+		p       string
+		changed bool
+	}{
+		{ms[0], "lib/a", true},
+		{ms[0], "lib/b", false},
+		{ms[1], "lib/a", false},
+	} {
+		got := readString(t, c.m, c.p)
+		if changed := got != string(content); changed != c.changed || len(got) != len(content) {
+			t.Errorf("%s of dev %d changed: %v, want %v", c.p, c.m.dev, changed, c.changed)
+		}
+	}
+
+	c := ms[2].Clone()
+	g, errno := c.OpenFile("lib/a", sys.O_RDWR, 0)
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	g.Truncate(100)
+	g.Close()
+	if got := readString(t, ms[2], "lib/a"); got != string(content) {
+		t.Error("truncating the file of a clone changed the source")
+	}
+	checkFS(t, c)
+}
+
+// TestBlobStoreConcurrent writes the same data from many MemFSs at once; it
+// is meant for the race detector.
+func TestBlobStoreConcurrent(t *testing.T) {
+	s := NewBlobStore()
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1000)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m := New(WithBlobStore(s))
+			for j := 0; j < 50; j++ {
+				p := fmt.Sprint("f", j%5)
+				if errno := m.WriteFile(p, content[:j*100], 0o644); errno != 0 {
+					t.Error(errno)
+				}
+				if got, errno := m.ReadFile(p); errno != 0 || !bytes.Equal(got, content[:j*100]) {
+					t.Errorf("%s is %d bytes, %v; want %d", p, len(got), errno, j*100)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
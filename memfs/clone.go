@@ -0,0 +1,102 @@
+package memfs
+
+import (
+	wasys "github.com/tetratelabs/wazero/sys"
+)
+
+// Clone returns a snapshot of m as a new, independent MemFS, in a time that
+// depends on the changes made to m since the last Clone, not on the size of
+// the tree.
+//
+// Both filesystems share all inodes, including file contents, copy-on-write:
+// an inode is copied by whichever side first changes it, so a run mutating a
+// clone never affects m or other clones of it, and the other way around.
+// Cloning a template many times without changing it in between doesn't make
+// the shared part any deeper.
+//
+// Files opened on m stay bound to m. The clone gets its own device ID and
+// keeps the options of m, including the limits, which it starts with the
+// usage of m.
+func (m *MemFS) Clone() *MemFS {
+	m.layerMu.Lock()
+	defer m.layerMu.Unlock()
+
+	if m.top.size() > 0 {
+		m.freeze()
+	}
+
+	c := &MemFS{
+		root:      m.root,
+		top:       newLayer(m.top.below),
+		opens:     map[wasys.Inode]int{},
+		dev:       defaultDev + lastClone.Add(1),
+		lastIno:   m.lastIno,
+		clock:     m.clock,
+		checkPerm: m.checkPerm,
+		spill:     m.spill,
+		blobs:     m.blobs,
+	}
+	c.quota.limits = m.quota.limits
+	c.quota.bytes.Store(m.quota.bytes.Load())
+	c.quota.inodes.Store(m.quota.inodes.Load())
+
+	// files unlinked but still open on m are not in the clone
+	m.opensMu.Lock()
+	for ino := range m.opens {
+		if n := m.get(ino); n != nil && n.nlink == 0 {
+			c.quota.inodes.Add(-1)
+			c.quota.bytes.Add(-n.allocated)
+		}
+	}
+	m.opensMu.Unlock()
+	return c
+}
+
+// freeze freezes the changes since the last Clone, so that they can be shared;
+// m.layerMu must be held for writing.
+//
+// Each frozen layer would make lookups slower, so the frozen layers below that
+// are not more than twice as large are merged into the one being frozen, by
+// copying their entries; the layers themselves are left as they are for the
+// clones still using them. Layers then get at least twice as large at each
+// level down, so there are only a few of them, and every entry is copied only
+// a few times over all the Clones.
+func (m *MemFS) freeze() {
+	l := m.top
+	for b := l.below; b != nil && b.size() <= 2*l.size(); b = l.below {
+		// before the inodes, which the atimes of b override
+		for ino, atim := range b.atimes {
+			_, changed := l.inodes[ino]
+			if _, ok := l.atimes[ino]; !ok && !changed {
+				l.atimes[ino] = atim
+			}
+		}
+		for ino, n := range b.inodes {
+			if _, ok := l.inodes[ino]; !ok {
+				l.inodes[ino] = n
+			}
+			if l.inodes[ino] == nil && !b.below.has(ino) {
+				// a tombstone with nothing left to hide
+				delete(l.inodes, ino)
+			}
+		}
+		l.below = b.below
+	}
+	m.top = newLayer(l)
+}
+
+// size returns the number of entries of l.
+func (l *layer) size() int {
+	return len(l.inodes) + len(l.atimes)
+}
+
+// has reports whether l or a layer below it has the inode ino, or a
+// tombstone for it; l can be nil.
+func (l *layer) has(ino wasys.Inode) bool {
+	for ; l != nil; l = l.below {
+		if _, ok := l.inodes[ino]; ok {
+			return true
+		}
+	}
+	return false
+}
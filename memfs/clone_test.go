@@ -0,0 +1,232 @@
+package memfs
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tetratelabs/wazero/experimental/sys"
+)
+
+func readString(t *testing.T, m *MemFS, p string) string {
+	t.Helper()
+	content, errno := m.ReadFile(p)
+	if errno != 0 {
+		t.Fatalf("ReadFile(%s): %v", p, errno)
+	}
+	return string(content)
+}
+
+func TestClone(t *testing.T) {
+	m := New()
+	if errno := m.WriteFile("d/f", []byte("template"), 0o644); errno != 0 {
+		t.Fatal(errno)
+	}
+	if errno := m.Link("d/f", "h"); errno != 0 {
+		t.Fatal(errno)
+	}
+	f, errno := m.OpenFile("d/f", sys.O_RDWR, 0)
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	defer f.Close()
+
+	c1, c2 := m.Clone(), m.Clone()
+	if c1.top.below != c2.top.below || m.top.below != c1.top.below {
+		t.Fatal("clones of an unchanged tree don't share the same layer")
+	}
+
+	if errno := c1.WriteFile("d/f", []byte("CLONE"), 0o644); errno != 0 {
+		t.Fatal(errno)
+	}
+	if _, errno := f.Pwrite([]byte("ORIG"), 0); errno != 0 {
+		t.Fatal(errno)
+	}
+	for _, c := range []struct {
+		m    *MemFS
+		p    string
+		want string
+	}{
+		{m, "h", "ORIGlate"},
+		{c1, "h", "CLONE"},
+		{c1, "d/f", "CLONE"},
+		{c2, "d/f", "template"},
+	} {
+		if got := readString(t, c.m, c.p); got != c.want {
+			t.Errorf("%s of dev %d is %q, want %q", c.p, c.m.dev, got, c.want)
+		}
+	}
+
+	if errno := m.Unlink("h"); errno != 0 {
+		t.Fatal(errno)
+	}
+	if errno := m.Rename("d", "e"); errno != 0 {
+		t.Fatal(errno)
+	}
+	for _, p := range []string{"h", "d/f"} {
+		if _, errno := c2.Stat(p); errno != 0 {
+			t.Errorf("Stat(%s) of the clone = %v", p, errno)
+		}
+	}
+
+	c3 := c1.Clone()
+	c3.Unlink("d/f")
+	c3.Unlink("h")
+	if _, errno := c1.Stat("h"); errno != 0 {
+		t.Errorf("Stat(h) of the source = %v", errno)
+	}
+	for _, x := range []*MemFS{c1, c2, c3} {
+		checkFS(t, x)
+	}
+}
+
+func TestCloneRelease(t *testing.T) {
+	m := New()
+	if errno := m.WriteFile("b", []byte("x"), 0o644); errno != 0 {
+		t.Fatal(errno)
+	}
+	c := m.Clone()
+	st, _ := c.Stat("b")
+	if errno := c.Unlink("b"); errno != 0 {
+		t.Fatal(errno)
+	}
+	ino := st.Ino
+	if n, ok := c.top.inodes[ino]; !ok || n != nil {
+		t.Errorf("the clone has no tombstone for the frozen inode %d", ino)
+	}
+	if _, errno := m.Stat("b"); errno != 0 {
+		t.Errorf("Stat(b) of the source = %v", errno)
+	}
+}
+
+// TestCloneConcurrent runs random operations on clones while they are being
+// cloned; it is meant for the race detector.
+func TestCloneConcurrent(t *testing.T) {
+	var mu sync.Mutex
+	fss := []*MemFS{New()}
+	next := 0
+	pick := func() *MemFS {
+		mu.Lock()
+		defer mu.Unlock()
+		next++
+		return fss[next%len(fss)]
+	}
+	clone := func(m *MemFS) {
+		c := m.Clone()
+		mu.Lock()
+		fss = append(fss, c)
+		mu.Unlock()
+	}
+	runRandomOps(t, pick, 8, 5000, clone)
+	for _, m := range fss {
+		checkFS(t, m)
+	}
+}
+
+func TestCloneReadsShare(t *testing.T) {
+	now := int64(1000)
+	m := New(WithClock(func() time.Time { return time.Unix(0, now) }))
+	if errno := m.WriteFile("d/f", []byte("content"), 0o644); errno != 0 {
+		t.Fatal(errno)
+	}
+	c := m.Clone()
+
+	now = 2000
+	readString(t, c, "d/f")
+	if dirents, errno := c.readDir("d"); errno != 0 || len(dirents) != 1 {
+		t.Fatalf("readDir(d) = %v, %v", dirents, errno)
+	}
+	if len(c.top.inodes) != 0 {
+		t.Errorf("reads copied %d inodes to the top layer of the clone", len(c.top.inodes))
+	}
+	for _, p := range []string{"d", "d/f"} {
+		if st, _ := c.Stat(p); st.Atim != 2000 {
+			t.Errorf("atime of %s in the clone is %d, want 2000", p, st.Atim)
+		}
+		if st, _ := m.Stat(p); st.Atim != 1000 {
+			t.Errorf("atime of %s in the source is %d, want 1000", p, st.Atim)
+		}
+	}
+
+	// a repeat read only updates the slot added by the first one
+	st, _ := c.Stat("d/f")
+	slot := c.top.atimes[st.Ino]
+	readString(t, c, "d/f")
+	if slot == nil || c.top.atimes[st.Ino] != slot {
+		t.Error("the access time slot of d/f was not reused")
+	}
+
+	// kept once the inode is copied, and through another Clone
+	now = 3000
+	if errno := c.Chmod("d/f", 0o600); errno != 0 {
+		t.Fatal(errno)
+	}
+	c2 := c.Clone()
+	for _, x := range []*MemFS{c, c2} {
+		if st, _ := x.Stat("d/f"); st.Atim != 2000 || st.Ctim != 3000 {
+			t.Errorf("times of d/f of dev %d are %d and %d, want 2000 and 3000", x.dev, st.Atim, st.Ctim)
+		}
+		if st, _ := x.Stat("d"); st.Atim != 2000 {
+			t.Errorf("atime of d of dev %d is %d, want 2000", x.dev, st.Atim)
+		}
+	}
+}
+
+func TestCloneLayers(t *testing.T) {
+	m := New()
+	if errno := m.WriteFile("f", []byte("content"), 0o644); errno != 0 {
+		t.Fatal(errno)
+	}
+	kept := m.Clone()
+	for i := 0; i < 1000; i++ {
+		if errno := m.Utimens("f", int64(i), int64(i)); errno != 0 {
+			t.Fatal(errno)
+		}
+		m.Clone()
+	}
+	depth := 0
+	for l := m.top; l != nil; l = l.below {
+		depth++
+	}
+	if depth > 3 {
+		t.Errorf("%d layers after 1000 clones", depth)
+	}
+
+	if st, _ := m.Stat("f"); st.Mtim != 999 {
+		t.Errorf("mtime of f is %d, want 999", st.Mtim)
+	}
+	if st, _ := kept.Stat("f"); st.Mtim == 999 {
+		t.Error("the change is seen by the first clone")
+	}
+	if errno := m.WriteFile("f", []byte("changed"), 0o644); errno != 0 {
+		t.Fatal(errno)
+	}
+	if got := readString(t, kept, "f"); got != "content" {
+		t.Errorf("f of the first clone is %q", got)
+	}
+	checkFS(t, m)
+	checkFS(t, kept)
+}
+
+func TestCloneLayersAtime(t *testing.T) {
+	now := int64(1000)
+	m := New(WithClock(func() time.Time { return time.Unix(0, now) }))
+	if errno := m.WriteFile("f", []byte("content"), 0o644); errno != 0 {
+		t.Fatal(errno)
+	}
+	m.Clone()
+	now = 50000
+	readString(t, m, "f")
+	// the layer holding the atime is merged into the ones above
+	for i := 0; i < 10; i++ {
+		now++
+		m.Clone()
+		if errno := m.Mkdir(fmt.Sprint("d", i), 0o755); errno != 0 {
+			t.Fatal(errno)
+		}
+	}
+	if st, _ := m.Stat("f"); st.Atim != 50000 {
+		t.Errorf("atime of f is %d, want 50000", st.Atim)
+	}
+}
@@ -0,0 +1,179 @@
+package memfs
+
+import (
+	"io"
+	"io/fs"
+	"path"
+
+	"github.com/tetratelabs/wazero/experimental/sys"
+)
+
+// ReadLinkFS is an fs.FS that can read symbolic links, with the same methods
+// as fs.ReadLinkFS of Go 1.25, which os.DirFS implements there. CopyFrom uses
+// it to copy symlinks as symlinks.
+type ReadLinkFS interface {
+	fs.FS
+
+	// ReadLink returns the target of the symbolic link name.
+	ReadLink(name string) (string, error)
+
+	// Lstat returns a FileInfo describing name, without following a
+	// symbolic link.
+	Lstat(name string) (fs.FileInfo, error)
+}
+
+// FromFS creates a new memory filesystem with opts, holding a copy of fsys.
+// See CopyFrom.
+func FromFS(fsys fs.FS, opts ...Option) (*MemFS, error) {
+	m := New(opts...)
+	if err := m.CopyFrom(fsys, "/"); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// CopyFrom copies the whole tree of fsys into the directory dst, creating dst
+// and its parents as needed. Files already in dst are overwritten, directories
+// are merged.
+//
+// Directories, regular files and their permission bits and modification
+// times are kept; a zero time, as reported by embed.FS, is replaced by the
+// current time. Symlinks are copied as symlinks if fsys implements ReadLinkFS,
+// or if it reports them without following them, as archive/zip does (the
+// content being the target); otherwise the file or directory they point to is
+// copied. Other file types, such as devices, are skipped.
+//
+// The first error stops the copy, leaving what was copied so far; it is
+// a *fs.PathError with the path in fsys.
+func (m *MemFS) CopyFrom(fsys fs.FS, dst string) error {
+	if errno := m.MkdirAll(dst, 0o777); errno != 0 {
+		return &fs.PathError{Op: "copy", Path: ".", Err: errno}
+	}
+	return m.copyDir(fsys, ".", dst, 0)
+}
+
+// copyDir copies the entries of the directory src of fsys into dst. hops
+// counts the symlinked directories followed, to stop loops.
+func (m *MemFS) copyDir(fsys fs.FS, src, dst string, hops int) error {
+	entries, err := fs.ReadDir(fsys, src)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		from, to := path.Join(src, e.Name()), path.Join(dst, e.Name())
+		if err := m.copyEntry(fsys, from, to, e.Type(), hops); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyEntry copies the entry src of fsys of type typ to dst.
+func (m *MemFS) copyEntry(fsys fs.FS, src, dst string, typ fs.FileMode, hops int) error {
+	if typ&fs.ModeSymlink != 0 {
+		target, ok, err := readLink(fsys, src)
+		if err != nil {
+			return err
+		}
+		if ok {
+			errno := m.Symlink(target, dst)
+			if errno == sys.EEXIST {
+				// overwrite, unless it's a directory
+				if errno = m.Unlink(dst); errno == 0 {
+					errno = m.Symlink(target, dst)
+				}
+			}
+			if errno != 0 {
+				return &fs.PathError{Op: "copy", Path: src, Err: errno}
+			}
+			return nil
+		}
+		// fsys follows it; copy what it points to
+		hops++
+		if hops > maxSymlinkHops {
+			return &fs.PathError{Op: "copy", Path: src, Err: sys.ELOOP}
+		}
+	}
+
+	info, err := fs.Stat(fsys, src)
+	if err != nil {
+		return err
+	}
+	switch {
+	case info.IsDir():
+		if errno := m.Mkdir(dst, 0o700); errno != 0 && errno != sys.EEXIST {
+			return &fs.PathError{Op: "copy", Path: src, Err: errno}
+		}
+		if err := m.copyDir(fsys, src, dst, hops); err != nil {
+			return err
+		}
+	case info.Mode().IsRegular():
+		if err := m.copyFile(fsys, src, dst); err != nil {
+			return err
+		}
+	default:
+		return nil
+	}
+	// set after filling, so that a read-only directory can still be filled
+	// and its mtime isn't changed by adding the entries
+	return m.copyAttrs(src, dst, info)
+}
+
+// readLink returns the target of the symlink src, if fsys can tell it.
+func readLink(fsys fs.FS, src string) (target string, ok bool, err error) {
+	if rfs, ok := fsys.(ReadLinkFS); ok {
+		target, err = rfs.ReadLink(src)
+		return target, err == nil, err
+	}
+	info, err := fs.Stat(fsys, src)
+	if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		// followed, or dangling
+		return "", false, err
+	}
+	content, err := fs.ReadFile(fsys, src)
+	return string(content), err == nil, err
+}
+
+// copyFile copies the content of the regular file src of fsys to dst.
+func (m *MemFS) copyFile(fsys fs.FS, src, dst string) error {
+	r, err := fsys.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	f, errno := m.OpenFile(dst, sys.O_WRONLY|sys.O_CREAT|sys.O_TRUNC, 0o600)
+	if errno != 0 {
+		return &fs.PathError{Op: "copy", Path: src, Err: errno}
+	}
+	defer f.Close()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, errno := f.Write(buf[:n]); errno != 0 {
+				return &fs.PathError{Op: "copy", Path: src, Err: errno}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// copyAttrs sets the mode and modification time of dst from info of src.
+func (m *MemFS) copyAttrs(src, dst string, info fs.FileInfo) error {
+	if errno := m.Chmod(dst, info.Mode()&chmodMask); errno != 0 {
+		return &fs.PathError{Op: "copy", Path: src, Err: errno}
+	}
+	if mtime := info.ModTime(); !mtime.IsZero() {
+		if errno := m.Utimens(dst, sys.UTIME_OMIT, mtime.UnixNano()); errno != 0 {
+			return &fs.PathError{Op: "copy", Path: src, Err: errno}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package memfs
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestFromFS(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "a/b"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a/b/f"), []byte("hello"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Unix(1000, 0)
+	for _, p := range []string{"a/b/f", "a"} {
+		if err := os.Chtimes(filepath.Join(dir, p), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := FromFS(os.DirFS(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st, _ := m.Stat("a/b/f"); st.Mode != 0o640 || st.Mtim != mtime.UnixNano() {
+		t.Errorf("a/b/f has mode %v and mtime %d", st.Mode, st.Mtim)
+	}
+	// set after its entries were copied
+	if st, _ := m.Stat("a"); st.Mtim != mtime.UnixNano() {
+		t.Errorf("a has mtime %d", st.Mtim)
+	}
+	checkFS(t, m)
+}
+
+func TestCopyFrom(t *testing.T) {
+	m := New(WithPermissions())
+	fsys := fstest.MapFS{
+		"x":   {Mode: fs.ModeDir | 0o555},
+		"x/y": {Data: []byte("1"), Mode: 0o444},
+	}
+	if err := m.CopyFrom(fsys, "/dst/sub"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readString(t, m, "dst/sub/x/y"); got != "1" {
+		t.Errorf("dst/sub/x/y is %q", got)
+	}
+	if st, _ := m.Stat("dst/sub/x"); st.Mode != fs.ModeDir|0o555 {
+		t.Errorf("dst/sub/x has mode %v", st.Mode)
+	}
+}
+
+// linkFS is a MapFS whose symlinks hold their target as data, read through
+// ReadLinkFS whatever the version of Go.
+type linkFS struct {
+	fstest.MapFS
+}
+
+func (f linkFS) ReadLink(name string) (string, error) {
+	file := f.MapFS[name]
+	if file == nil || file.Mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return string(file.Data), nil
+}
+
+// Lstat returns the info of the entry of name in its directory, which is not
+// followed.
+func (f linkFS) Lstat(name string) (fs.FileInfo, error) {
+	entries, err := fs.ReadDir(f.MapFS, path.Dir(name))
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.Name() == path.Base(name) {
+			return e.Info()
+		}
+	}
+	return nil, &fs.PathError{Op: "lstat", Path: name, Err: fs.ErrNotExist}
+}
+
+func TestCopySymlinks(t *testing.T) {
+	m := New()
+	fsys := linkFS{fstest.MapFS{
+		"a/b/f": {Data: []byte("hello")},
+		"a/l":   {Data: []byte("b/f"), Mode: fs.ModeSymlink},
+		"a/abs": {Data: []byte("/a/b"), Mode: fs.ModeSymlink},
+	}}
+	if err := m.CopyFrom(fsys, "/"); err != nil {
+		t.Fatal(err)
+	}
+	for p, want := range map[string]string{"a/l": "b/f", "a/abs": "/a/b"} {
+		if target, errno := m.Readlink(p); errno != 0 || target != want {
+			t.Errorf("Readlink(%s) = %q, %v; want %q", p, target, errno, want)
+		}
+	}
+	if got := readString(t, m, "a/l"); got != "hello" {
+		t.Errorf("a/l is %q", got)
+	}
+	if got := readString(t, m, "a/abs/f"); got != "hello" {
+		t.Errorf("a/abs/f is %q", got)
+	}
+}
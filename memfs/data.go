@@ -0,0 +1,387 @@
+package memfs
+
+import (
+	"io"
+	"maps"
+	"sync/atomic"
+
+	"github.com/tetratelabs/wazero/experimental/sys"
+)
+
+// chunkSize is the size of the chunks a file content is stored in, the block
+// size of most filesystems. Chunks are only allocated when written, so holes
+// left by Pwrite past the end or by Truncate take no memory.
+const chunkSize = 4 << 10
+
+// minBufferSize is the initial capacity of a chunk.
+const minBufferSize = 512
+
+// blockSize is the unit of Blocks, as st_blocks.
+const blockSize = 512
+
+// lastGen is the last generation given to an inode, see chunk.
+var lastGen atomic.Uint64
+
+// chunk is a part of a file content. It belongs to the inode of the same
+// generation; other inodes share it with a frozen inode, or with a BlobStore
+// for generation 0, so they copy it before writing.
+type chunk struct {
+	gen uint64
+	// data is the start of the chunk, at most chunkSize long; the rest up to
+	// the file size reads as zeros.
+	data []byte
+	// b is set instead of data once the chunk is spilled, see WithSpill;
+	// size is then the length of data it stands for.
+	b    *block
+	size int
+}
+
+// len returns the length of the data of c.
+func (c *chunk) len() int {
+	if c.b != nil {
+		return c.size
+	}
+	return len(c.data)
+}
+
+// read copies the data of c at off into buf and returns the count copied.
+func (c *chunk) read(buf []byte, off int) (int, sys.Errno) {
+	if c.b == nil {
+		return copy(buf, c.data[off:]), 0
+	}
+	buf = buf[:min(len(buf), c.size-off)]
+	return len(buf), c.b.readAt(buf, off)
+}
+
+// readAt copies the content of n at off into buf and returns the count read;
+// zero at or past the end.
+func (n *inode) readAt(buf []byte, off int64) (int, sys.Errno) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.read(buf, off)
+}
+
+// read is readAt with n.mu held.
+func (n *inode) read(buf []byte, off int64) (int, sys.Errno) {
+	if off >= n.fileSize {
+		return 0, 0
+	}
+	if rest := n.fileSize - off; int64(len(buf)) > rest {
+		buf = buf[:rest]
+	}
+	for done := 0; done < len(buf); {
+		pos := off + int64(done)
+		idx, co := pos/chunkSize, int(pos%chunkSize)
+		part := buf[done:min(len(buf), done+chunkSize-co)]
+
+		copied := 0
+		if c := n.chunks[idx]; c != nil && co < c.len() {
+			var errno sys.Errno
+			if copied, errno = c.read(part, co); errno != 0 {
+				return done, errno
+			}
+		}
+		// a hole
+		clear(part[copied:])
+		done += len(part)
+	}
+	return len(buf), 0
+}
+
+// writeTo writes the whole content of n to w, holes as zeros; n.mu must be
+// held.
+func (n *inode) writeTo(w io.Writer) error {
+	buf := make([]byte, chunkSize)
+	for off := int64(0); ; {
+		count, errno := n.read(buf, off)
+		if errno != 0 {
+			return errno
+		}
+		if count == 0 {
+			return nil
+		}
+		if _, err := w.Write(buf[:count]); err != nil {
+			return err
+		}
+		off += int64(count)
+	}
+}
+
+// writeAt writes buf into n at off, extending it as needed within the limits
+// of q. A hole between the previous end and off reads as zeros. If sp is set,
+// the chunks of n go to it once n is larger than its threshold.
+func (n *inode) writeAt(q *quota, sp *spill, buf []byte, off int64) (int, sys.Errno) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.write(q, sp, buf, off)
+}
+
+// writeEnd writes buf at the end of n, as writeAt, and returns the offset it
+// was written at; the end is taken under the same lock, so that concurrent
+// appends never overwrite each other.
+func (n *inode) writeEnd(q *quota, sp *spill, buf []byte) (int64, int, sys.Errno) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	off := n.fileSize
+	count, errno := n.write(q, sp, buf, off)
+	return off, count, errno
+}
+
+// write is writeAt with n.mu held.
+func (n *inode) write(q *quota, sp *spill, buf []byte, off int64) (int, sys.Errno) {
+	end := off + int64(len(buf))
+	if end < off {
+		// overflow; it should be POSIX EFBIG but wazero maps that to EIO
+		return 0, sys.EIO
+	}
+	if len(buf) == 0 {
+		return 0, 0
+	}
+	if errno := q.fileSize(end); errno != 0 {
+		return 0, errno
+	}
+
+	// account the whole write first, so that it is done entirely or not at all
+	var alloc int64
+	for pos := off; pos < end; pos = (pos/chunkSize + 1) * chunkSize {
+		idx, co := pos/chunkSize, pos%chunkSize
+		want := min(chunkSize, co+end-pos)
+		if c := n.chunks[idx]; c != nil {
+			want -= int64(c.len())
+		}
+		alloc += max(0, want)
+	}
+	if errno := q.alloc(alloc); errno != 0 {
+		return 0, errno
+	}
+
+	spilled := sp != nil && max(n.fileSize, end) > sp.threshold
+	var errno sys.Errno
+	if spilled {
+		errno = n.spillChunks(sp)
+	}
+
+	// only an I/O error of the spill file can stop the write midway; the
+	// unused part of alloc is given back then
+	var grown int64
+	done := 0
+	for errno == 0 && done < len(buf) {
+		pos := off + int64(done)
+		idx, co := pos/chunkSize, int(pos%chunkSize)
+		part := buf[done:min(len(buf), done+chunkSize-co)]
+
+		c := n.chunks[idx]
+		prev := 0
+		if c != nil {
+			prev = c.len()
+		}
+		if spilled || c != nil && c.b != nil {
+			if c, errno = n.spilledChunk(sp, idx, co); errno == 0 {
+				if errno = c.b.writeAt(part, co); errno == 0 {
+					c.size = max(c.size, co+len(part))
+				}
+			}
+			if c != nil {
+				grown += int64(c.len() - prev)
+			}
+		} else {
+			c = n.chunk(idx, co+len(part))
+			copy(c.data[co:], part)
+			grown += int64(c.len() - prev)
+		}
+		if errno == 0 {
+			done += len(part)
+		}
+	}
+	if grown < alloc {
+		_ = q.alloc(grown - alloc)
+	}
+	n.allocated += grown
+	n.fileSize = max(n.fileSize, off+int64(done))
+	return done, errno
+}
+
+// chunk returns the chunk idx of n for writing, at least size long; n.mu must
+// be held, and the growth accounted.
+func (n *inode) chunk(idx int64, size int) *chunk {
+	n.ownChunks()
+
+	c := n.chunks[idx]
+	switch {
+	case c == nil:
+		c = &chunk{gen: n.gen}
+		n.chunks[idx] = c
+	case c.gen != n.gen:
+		// shared with a frozen inode
+		data := make([]byte, len(c.data), max(size, cap(c.data)))
+		copy(data, c.data)
+		c = &chunk{gen: n.gen, data: data}
+		n.chunks[idx] = c
+	}
+
+	if old := len(c.data); size > old {
+		if size > cap(c.data) {
+			data := make([]byte, old, min(chunkSize, max(size, 2*cap(c.data), minBufferSize)))
+			copy(data, c.data)
+			c.data = data
+		}
+		c.data = c.data[:size]
+		// the part past the old length may hold data cut by a truncate
+		clear(c.data[old:])
+	}
+	return c
+}
+
+// spilledChunk returns the chunk idx of n for writing, spilled to sp and at
+// least from long; n.mu must be held, and the growth accounted. If an error
+// is returned, the chunk may still have grown.
+func (n *inode) spilledChunk(sp *spill, idx int64, from int) (*chunk, sys.Errno) {
+	n.ownChunks()
+
+	c := n.chunks[idx]
+	if c == nil || c.b == nil || c.gen != n.gen {
+		// new, in memory or shared with a frozen inode
+		b, errno := sp.alloc()
+		if errno != 0 {
+			return c, errno
+		}
+		spilled := &chunk{gen: n.gen, b: b}
+		if c != nil && c.len() > 0 {
+			data := make([]byte, c.len())
+			if _, errno = c.read(data, 0); errno == 0 {
+				errno = b.writeAt(data, 0)
+			}
+			if errno != 0 {
+				b.release()
+				return c, errno
+			}
+			spilled.size = len(data)
+		}
+		c = spilled
+		n.chunks[idx] = c
+	}
+
+	if from > c.size {
+		// the block past the size may hold data cut by a truncate
+		if errno := c.b.writeAt(make([]byte, from-c.size), c.size); errno != 0 {
+			return c, errno
+		}
+		c.size = from
+	}
+	return c, 0
+}
+
+// spillChunks moves the chunks of n held in memory to sp; the ones shared
+// with a frozen inode take no more memory, so they stay. n.mu must be held.
+func (n *inode) spillChunks(sp *spill) sys.Errno {
+	for idx, c := range n.chunks {
+		if c.gen == n.gen && c.b == nil {
+			if _, errno := n.spilledChunk(sp, idx, 0); errno != 0 {
+				return errno
+			}
+		}
+	}
+	return 0
+}
+
+// ownChunks makes the chunks map of n its own, for changing it; n.mu must be
+// held.
+func (n *inode) ownChunks() {
+	if n.sharedChunks {
+		n.chunks = maps.Clone(n.chunks)
+		n.sharedChunks = false
+	}
+	if n.chunks == nil {
+		n.chunks = map[int64]*chunk{}
+	}
+}
+
+// truncate changes the size of n within the limits of q. Extending it only
+// adds a hole, which takes no memory.
+func (n *inode) truncate(q *quota, size int64) sys.Errno {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if errno := q.fileSize(size); errno != 0 {
+		return errno
+	}
+	if size < n.fileSize {
+		n.ownChunks()
+		var freed int64
+		for idx, c := range n.chunks {
+			start := idx * chunkSize
+			if start+int64(c.len()) <= size {
+				continue
+			}
+			if start >= size {
+				freed += int64(c.len())
+				if c.gen == n.gen && c.b != nil {
+					c.b.release()
+				}
+				delete(n.chunks, idx)
+				continue
+			}
+			keep := int(size - start)
+			freed += int64(c.len() - keep)
+			switch {
+			case c.gen == n.gen && c.b != nil:
+				c.size = keep
+			case c.gen == n.gen:
+				c.data = c.data[:keep]
+			case c.b != nil:
+				n.chunks[idx] = &chunk{gen: c.gen, b: c.b, size: keep}
+			default:
+				// the capacity is cut too, so that the shared chunk is never
+				// written by a later growth
+				n.chunks[idx] = &chunk{gen: c.gen, data: c.data[:keep:keep]}
+			}
+		}
+		_ = q.alloc(-freed)
+		n.allocated -= freed
+	}
+	n.fileSize = size
+	return 0
+}
+
+// drop gives back the content of n, which is being dropped, to q. If own is
+// set, n is of the top layer and its spilled chunks are freed, so its content
+// is emptied for anyone still holding it, see MemFS.list; the chunks of a
+// frozen inode are left to the garbage collector.
+func (n *inode) drop(q *quota, own bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	_ = q.alloc(-n.allocated)
+	if !own {
+		return
+	}
+	for _, c := range n.chunks {
+		if c.gen == n.gen && c.b != nil {
+			c.b.release()
+		}
+	}
+	n.chunks, n.sharedChunks, n.fileSize, n.allocated = nil, false, 0, 0
+}
+
+// blocks returns the number of blocks allocated to n; n.mu must be held.
+func (n *inode) blocks() int64 {
+	return (n.allocated + blockSize - 1) / blockSize
+}
+
+// Blocks returns the number of 512-byte blocks allocated to the file path,
+// following symlinks, like st_blocks, which wazero's Stat_t lacks. A sparse
+// file has fewer blocks than its size needs.
+func (m *MemFS) Blocks(path string) (int64, sys.Errno) {
+	m.layerMu.RLock()
+	defer m.layerMu.RUnlock()
+
+	_, _, n, errno := m.lookup(path, true)
+	if errno != 0 {
+		return 0, errno
+	}
+	if n == nil {
+		return 0, sys.ENOENT
+	}
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.blocks(), 0
+}
@@ -0,0 +1,96 @@
+package memfs
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tetratelabs/wazero/experimental/sys"
+)
+
+func TestSparse(t *testing.T) {
+	m := New(WithLimits(Limits{MaxBytes: 1 << 20}))
+	f, errno := m.OpenFile("img", sys.O_RDWR|sys.O_CREAT, 0o644)
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	defer f.Close()
+	const off = 1<<39 - 2
+	if errno := f.Truncate(1 << 40); errno != 0 {
+		t.Fatal(errno)
+	}
+	// across two chunks
+	if _, errno := f.Pwrite([]byte("hello"), off); errno != 0 {
+		t.Fatal(errno)
+	}
+	if blocks, _ := m.Blocks("img"); blocks != 9 {
+		t.Errorf("img has %d blocks", blocks)
+	}
+	if u := m.Usage(); u.Bytes != 4099 {
+		t.Errorf("usage with img is %+v", u)
+	}
+	buf := bytes.Repeat([]byte{9}, 10)
+	if count, _ := f.Pread(buf, off-3); count != 10 || string(buf) != "\x00\x00\x00hello\x00\x00" {
+		t.Errorf("Pread around the data = %d, %q", count, buf)
+	}
+	if st, _ := f.Stat(); st.Size != 1<<40 {
+		t.Errorf("img has size %d", st.Size)
+	}
+	if errno := f.Truncate(off + 1); errno != 0 {
+		t.Fatal(errno)
+	}
+	if u := m.Usage(); u.Bytes != 4095 {
+		t.Errorf("usage once img is truncated is %+v", u)
+	}
+
+	// chunks shared with a clone
+	c := m.Clone()
+	if _, errno := f.Pwrite([]byte("X"), off); errno != 0 {
+		t.Fatal(errno)
+	}
+	g, errno := c.OpenFile("img", sys.O_RDWR, 0)
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	defer g.Close()
+	g.Pread(buf[:1], off)
+	if buf[0] != 'h' {
+		t.Errorf("img of the clone has %q", buf[0])
+	}
+	g.Truncate(10)
+	g.Truncate(1 << 39)
+	g.Pread(buf[:2], off)
+	f.Pread(buf[2:3], off)
+	if string(buf[:3]) != "\x00\x00X" {
+		t.Errorf("img of the clone and source have %q", buf[:3])
+	}
+
+	if _, errno := f.Pwrite(make([]byte, 1<<20), 0); errno != sys.EIO {
+		t.Errorf("Pwrite past MaxBytes = %v, want EIO", errno)
+	}
+}
+
+func TestTruncateChunk(t *testing.T) {
+	m := New()
+	data := make([]byte, 200000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	f, errno := m.OpenFile("big", sys.O_RDWR|sys.O_CREAT, 0o644)
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	defer f.Close()
+	if _, errno := f.Write(data); errno != 0 {
+		t.Fatal(errno)
+	}
+	// in the middle of a chunk, whose end must read as zeros once extended
+	f.Truncate(70000)
+	f.Truncate(200000)
+	got := make([]byte, 200000)
+	if _, errno := f.Pread(got, 0); errno != 0 {
+		t.Fatal(errno)
+	}
+	if !bytes.Equal(got[:70000], data[:70000]) || !bytes.Equal(got[70000:], make([]byte, 130000)) {
+		t.Error("the content is not kept up to the truncation and zeros after")
+	}
+}
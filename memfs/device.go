@@ -0,0 +1,231 @@
+package memfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	wasys "github.com/tetratelabs/wazero/sys"
+
+	"github.com/tetratelabs/wazero/experimental/sys"
+)
+
+// AddDevice creates a character device at path, whose reads and writes go to
+// dev, for guests expecting files like /dev/null. It is reported with
+// fs.ModeDevice|fs.ModeCharDevice and perm, has a size of 0, and ignores
+// offsets; an io.EOF from dev reads as the end of file, and other errors fail
+// with EIO, unless they are a sys.Errno.
+//
+// dev is used by all files opened on the device, from any goroutine, and is
+// shared with clones; see NullDevice, ZeroDevice and RandomDevice.
+func (m *MemFS) AddDevice(path string, perm fs.FileMode, dev io.ReadWriter) sys.Errno {
+	m.layerMu.RLock()
+	defer m.layerMu.RUnlock()
+
+	dir, name, n, errno := m.lookup(path, false)
+	if errno != 0 {
+		return errno
+	}
+	if n != nil {
+		return sys.EEXIST
+	}
+	if dirPath(path) {
+		return sys.ENOENT
+	}
+	n = m.newInode(fs.ModeDevice | fs.ModeCharDevice | perm&fs.ModePerm)
+	n.device = dev
+	existing, errno := m.addNewEntry(dir, name, n)
+	if errno != 0 {
+		return errno
+	}
+	if existing != nil {
+		return sys.EEXIST
+	}
+	m.notify(Create, path, n.ino)
+	return 0
+}
+
+// AddStdDevices creates the directory /dev, if missing, with the devices
+// null, zero, and random and urandom both reading a RandomDevice of seed, so
+// that the root of a MemFS looks like a minimal Unix system.
+func (m *MemFS) AddStdDevices(seed int64) sys.Errno {
+	if errno := m.MkdirAll("/dev", 0o755); errno != 0 {
+		return errno
+	}
+	random := RandomDevice(seed)
+	for _, d := range []struct {
+		name string
+		dev  io.ReadWriter
+	}{
+		{"null", NullDevice()},
+		{"zero", ZeroDevice()},
+		{"random", random},
+		{"urandom", random},
+	} {
+		if errno := m.AddDevice("/dev/"+d.name, 0o666, d.dev); errno != 0 {
+			return errno
+		}
+	}
+	return 0
+}
+
+// NullDevice returns a device like /dev/null: reads are at the end of file,
+// writes are discarded.
+func NullDevice() io.ReadWriter {
+	return nullDevice{}
+}
+
+type nullDevice struct{}
+
+func (nullDevice) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (nullDevice) Write(buf []byte) (int, error) {
+	return len(buf), nil
+}
+
+// ZeroDevice returns a device like /dev/zero: reads return zeros, writes are
+// discarded.
+func ZeroDevice() io.ReadWriter {
+	return zeroDevice{}
+}
+
+type zeroDevice struct{}
+
+func (zeroDevice) Read(buf []byte) (int, error) {
+	clear(buf)
+	return len(buf), nil
+}
+
+func (zeroDevice) Write(buf []byte) (int, error) {
+	return len(buf), nil
+}
+
+// RandomDevice returns a device like /dev/urandom, except that reads return
+// a pseudo-random sequence fully determined by seed, so that runs are
+// reproducible; it is not suitable for cryptography. Writes are discarded.
+func RandomDevice(seed int64) io.ReadWriter {
+	return &randomDevice{rand: rand.New(rand.NewSource(seed))}
+}
+
+type randomDevice struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func (d *randomDevice) Read(buf []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.rand.Read(buf)
+}
+
+func (d *randomDevice) Write(buf []byte) (int, error) {
+	return len(buf), nil
+}
+
+// memoryFSDevice is an opened character device. Reads and writes go to dev
+// without any lock, so a blocking device doesn't block Clone.
+type memoryFSDevice struct {
+	m      *MemFS
+	ino    wasys.Inode
+	flag   sys.Oflag
+	dev    io.ReadWriter
+	closed atomic.Bool
+
+	sys.UnimplementedFile
+}
+
+func (f *memoryFSDevice) Stat() (wasys.Stat_t, sys.Errno) {
+	if f.closed.Load() {
+		return wasys.Stat_t{}, sys.EBADF
+	}
+	f.m.layerMu.RLock()
+	defer f.m.layerMu.RUnlock()
+	return f.m.statOf(f.m.get(f.ino)), 0
+}
+
+func (f *memoryFSDevice) Close() sys.Errno {
+	if !f.closed.Swap(true) {
+		f.m.layerMu.RLock()
+		defer f.m.layerMu.RUnlock()
+		f.m.closed(f.ino)
+	}
+	return 0
+}
+
+// Dev returns the device ID of the filesystem as defined in sys.File.
+func (f *memoryFSDevice) Dev() (uint64, sys.Errno) {
+	return f.m.dev, 0
+}
+
+// Ino returns the inode number as defined in sys.File.
+func (f *memoryFSDevice) Ino() (wasys.Inode, sys.Errno) {
+	return f.ino, 0
+}
+
+func (f *memoryFSDevice) IsDir() (bool, sys.Errno) {
+	return false, 0
+}
+
+func (f *memoryFSDevice) Read(buf []byte) (int, sys.Errno) {
+	if f.closed.Load() || f.flag&(sys.O_RDONLY|sys.O_RDWR|sys.O_WRONLY) == sys.O_WRONLY {
+		return 0, sys.EBADF
+	}
+	if len(buf) == 0 {
+		return 0, 0
+	}
+	n, err := f.dev.Read(buf)
+	return n, deviceErrno(err)
+}
+
+func (f *memoryFSDevice) Write(buf []byte) (int, sys.Errno) {
+	if f.closed.Load() || f.flag&(sys.O_RDONLY|sys.O_RDWR|sys.O_WRONLY) == sys.O_RDONLY {
+		return 0, sys.EBADF
+	}
+	if len(buf) == 0 {
+		return 0, 0
+	}
+	n, err := f.dev.Write(buf)
+	return n, deviceErrno(err)
+}
+
+// Pread reads as Read; a device has no offset.
+func (f *memoryFSDevice) Pread(buf []byte, off int64) (int, sys.Errno) {
+	if off < 0 {
+		return 0, sys.EINVAL
+	}
+	return f.Read(buf)
+}
+
+// Pwrite writes as Write; a device has no offset.
+func (f *memoryFSDevice) Pwrite(buf []byte, off int64) (int, sys.Errno) {
+	if off < 0 {
+		return 0, sys.EINVAL
+	}
+	return f.Write(buf)
+}
+
+// Seek does nothing and returns 0, as on Linux.
+func (f *memoryFSDevice) Seek(int64, int) (int64, sys.Errno) {
+	if f.closed.Load() {
+		return 0, sys.EBADF
+	}
+	return 0, 0
+}
+
+// deviceErrno converts an error of a device to an Errno; io.EOF is no error.
+func deviceErrno(err error) sys.Errno {
+	var errno sys.Errno
+	switch {
+	case err == nil, err == io.EOF:
+		return 0
+	case errors.As(err, &errno):
+		return errno
+	default:
+		return sys.EIO
+	}
+}
@@ -0,0 +1,70 @@
+package memfs
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/tetratelabs/wazero/experimental/sys"
+)
+
+func readDevice(t *testing.T, m *MemFS, p string, size int) []byte {
+	t.Helper()
+	f, errno := m.OpenFile(p, sys.O_RDONLY, 0)
+	if errno != 0 {
+		t.Fatalf("OpenFile(%s): %v", p, errno)
+	}
+	defer f.Close()
+	buf := bytes.Repeat([]byte{1}, size)
+	count, errno := f.Read(buf)
+	if errno != 0 {
+		t.Fatalf("Read(%s): %v", p, errno)
+	}
+	return buf[:count]
+}
+
+func TestStdDevices(t *testing.T) {
+	m := New()
+	if errno := m.AddStdDevices(42); errno != 0 {
+		t.Fatal(errno)
+	}
+	f, errno := m.OpenFile("/dev/null", sys.O_RDWR|sys.O_TRUNC, 0)
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	if count, errno := f.Write([]byte("abc")); count != 3 || errno != 0 {
+		t.Errorf("Write to /dev/null = %d, %v", count, errno)
+	}
+	if st, _ := f.Stat(); st.Mode&fs.ModeCharDevice == 0 || st.Size != 0 {
+		t.Errorf("/dev/null has mode %v and size %d", st.Mode, st.Size)
+	}
+	f.Close()
+
+	if got := readDevice(t, m, "/dev/null", 8); len(got) != 0 {
+		t.Errorf("read %q from /dev/null", got)
+	}
+	if got := readDevice(t, m, "/dev/zero", 8); !bytes.Equal(got, make([]byte, 8)) {
+		t.Errorf("read %q from /dev/zero", got)
+	}
+	// reproducible for the same seed
+	other := New()
+	if errno := other.AddStdDevices(42); errno != 0 {
+		t.Fatal(errno)
+	}
+	got := readDevice(t, m, "/dev/urandom", 16)
+	if want := readDevice(t, other, "/dev/urandom", 16); len(got) != 16 || !bytes.Equal(got, want) {
+		t.Errorf("read %x and %x from /dev/urandom with the same seed", got, want)
+	}
+
+	dirents, errno := m.readDir("/dev")
+	if errno != 0 || len(dirents) != 4 {
+		t.Errorf("readDir(/dev) = %v, %v", dirents, errno)
+	}
+	if content, err := fs.ReadFile(m.FS(), "dev/null"); err != nil || len(content) != 0 {
+		t.Errorf("ReadFile(dev/null) of the io/fs view = %q, %v", content, err)
+	}
+	if _, err := fs.ReadFile(m.FS(), "dev/nope"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadFile(dev/nope) of the io/fs view = %v", err)
+	}
+}
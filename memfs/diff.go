@@ -0,0 +1,316 @@
+package memfs
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+
+	wasys "github.com/tetratelabs/wazero/sys"
+)
+
+// ChangeKind is the kind of a Change.
+type ChangeKind uint8
+
+const (
+	// Added is an entry of b only.
+	Added ChangeKind = iota
+	// Removed is an entry of a only.
+	Removed
+	// Modified is an entry whose content changed: the data of a regular
+	// file, or the target of a symlink.
+	Modified
+	// MetadataChanged is an entry whose mode bits or modification time
+	// changed, but not its content.
+	MetadataChanged
+	// Renamed is an entry moved from OldPath, possibly also changed.
+	Renamed
+)
+
+var changeKindNames = [...]string{"added", "removed", "modified", "metadata", "renamed"}
+
+func (k ChangeKind) String() string {
+	if int(k) < len(changeKindNames) {
+		return changeKindNames[k]
+	}
+	return "unknown"
+}
+
+// DiffEntry is a file or directory of one side of a Diff.
+type DiffEntry struct {
+	// Stat is the Lstat of the entry, with the Dev of its MemFS.
+	Stat wasys.Stat_t
+	// Target is the target of a symlink.
+	Target string
+	// Hash is the SHA-256 of the content of a regular file.
+	Hash [sha256.Size]byte
+}
+
+// Change is a difference between two trees; see Diff.
+type Change struct {
+	Kind ChangeKind
+	// Path is the path in b, or in a for Removed; paths are absolute.
+	Path string
+	// OldPath is the path in a of Renamed, and of Modified and
+	// MetadataChanged entries of a renamed directory.
+	OldPath string
+	// Old is the entry in a, nil for Added; New is the entry in b, nil for
+	// Removed.
+	Old, New *DiffEntry
+}
+
+// ContentChanged reports whether the content of the entry changed, which a
+// Renamed entry may also have.
+func (c Change) ContentChanged() bool {
+	if c.Old == nil || c.New == nil {
+		return true
+	}
+	return c.Old.Target != c.New.Target || c.Old.Stat.Size != c.New.Stat.Size || c.Old.Hash != c.New.Hash
+}
+
+// metadataChanged reports whether the mode bits or the modification time
+// changed from a to b; the modification time of directories is ignored, as it
+// changes with their entries, which are reported themselves.
+func metadataChanged(a, b wasys.Stat_t) bool {
+	return a.Mode != b.Mode || !a.Mode.IsDir() && a.Mtim != b.Mtim
+}
+
+// String returns c as a line of the report of Changes.
+func (c Change) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-8s ", c.Kind)
+	if c.Kind == Renamed {
+		fmt.Fprintf(&b, "%s -> ", c.OldPath)
+	}
+	b.WriteString(c.Path)
+
+	var details []string
+	switch {
+	case c.Old == nil:
+		details = append(details, describeEntry(c.New))
+	case c.New == nil:
+		details = append(details, describeEntry(c.Old))
+	default:
+		if c.Kind != Renamed && c.OldPath != "" {
+			details = append(details, "from "+c.OldPath)
+		}
+		before, after := c.Old.Stat, c.New.Stat
+		if c.Old.Target != c.New.Target {
+			details = append(details, fmt.Sprintf("target %s -> %s", c.Old.Target, c.New.Target))
+		}
+		if before.Size != after.Size {
+			details = append(details, fmt.Sprintf("size %d -> %d", before.Size, after.Size))
+		} else if c.Old.Hash != c.New.Hash {
+			details = append(details, "content")
+		}
+		if before.Mode != after.Mode {
+			details = append(details, fmt.Sprintf("mode %v -> %v", before.Mode, after.Mode))
+		}
+		if !before.Mode.IsDir() && before.Mtim != after.Mtim {
+			details = append(details, "mtime")
+		}
+	}
+	if len(details) > 0 {
+		fmt.Fprintf(&b, " (%s)", strings.Join(details, ", "))
+	}
+	return b.String()
+}
+
+// describeEntry returns the type and size of e, for the report.
+func describeEntry(e *DiffEntry) string {
+	switch mode := e.Stat.Mode; {
+	case mode.IsDir():
+		return "directory"
+	case mode&fs.ModeSymlink != 0:
+		return "symlink to " + e.Target
+	case mode.IsRegular():
+		return fmt.Sprintf("%d bytes", e.Stat.Size)
+	default:
+		return mode.String()
+	}
+}
+
+// Changes is the result of Diff.
+type Changes []Change
+
+// String returns a report of all changes, one line each, for test failures
+// and change summaries; it is empty if there are none.
+func (cs Changes) String() string {
+	var b strings.Builder
+	for _, c := range cs {
+		b.WriteString(c.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// Diff returns the changes from a to b, ordered by path, for finding what a
+// guest changed in a Clone of a tree, or in the tree itself since a Clone.
+// The root is compared too, but is never added or removed.
+//
+// Entries are compared by path. Content is compared by size, then by hash;
+// files of a Clone that weren't changed since are known to be the same
+// without reading them. A path of a different type on each side is Removed
+// and Added.
+//
+// If a and b come from the same MemFS through Clone, an entry of a only and
+// one of b only with the same inode number are Renamed; renames are not
+// detected between unrelated trees, whose inode numbers have no relation.
+// The entries of a renamed directory are reported only if they changed
+// otherwise, with the OldPath.
+//
+// Both trees are listed at once, as in WriteTar, so a and b can be changed
+// meanwhile; the content of files is read as it is when they are compared.
+func Diff(a, b *MemFS) Changes {
+	d := differ{
+		a: newDiffSide(a),
+		b: newDiffSide(b),
+	}
+	return d.diff(a.lastIno == b.lastIno)
+}
+
+// diffSide is one tree of a Diff.
+type diffSide struct {
+	entries map[string]treeEntry
+	// paths are the keys of entries, sorted.
+	paths []string
+	// hashed holds the entries already hashed.
+	hashed map[*inode]*DiffEntry
+}
+
+// newDiffSide returns the tree of m, as listed by m.list.
+func newDiffSide(m *MemFS) *diffSide {
+	s := &diffSide{
+		entries: map[string]treeEntry{},
+		hashed:  map[*inode]*DiffEntry{},
+	}
+	for _, e := range m.list() {
+		p := "/" + e.path
+		s.entries[p] = e
+		s.paths = append(s.paths, p)
+	}
+	sort.Strings(s.paths)
+	return s
+}
+
+// entry returns the DiffEntry of e, hashing regular files once.
+func (s *diffSide) entry(e treeEntry) *DiffEntry {
+	n := e.n
+	if !n.isRegular() {
+		return &DiffEntry{Stat: e.st, Target: n.target}
+	}
+	if de := s.hashed[n]; de != nil {
+		return de
+	}
+	de := &DiffEntry{Stat: e.st}
+	h := sha256.New()
+	// reading from memory or a spill file doesn't fail
+	n.mu.RLock()
+	_ = n.writeTo(h)
+	n.mu.RUnlock()
+	h.Sum(de.Hash[:0])
+	s.hashed[n] = de
+	return de
+}
+
+type differ struct {
+	a, b *diffSide
+}
+
+// compare returns the change from ea to eb, of the same type, if any, as
+// either Modified or MetadataChanged.
+func (d *differ) compare(ea, eb treeEntry) (Change, bool) {
+	if ea.n == eb.n {
+		// shared by the clones, so unchanged
+		return Change{}, false
+	}
+	// sizes first, as hashing reads the files
+	before, after := ea.st, eb.st
+	var kind ChangeKind
+	switch {
+	case ea.n.target != eb.n.target, before.Size != after.Size:
+		kind = Modified
+	case ea.n.isRegular() && d.a.entry(ea).Hash != d.b.entry(eb).Hash:
+		kind = Modified
+	case metadataChanged(before, after):
+		kind = MetadataChanged
+	default:
+		return Change{}, false
+	}
+	return Change{Kind: kind, Old: d.a.entry(ea), New: d.b.entry(eb)}, true
+}
+
+func (d *differ) diff(renames bool) Changes {
+	// a path of a different type on each side is both removed and added
+	var changes Changes
+	var removed []string
+	for _, p := range d.a.paths {
+		ea, eb := d.a.entries[p], d.b.entries[p]
+		if eb.n == nil || ea.n.typ != eb.n.typ {
+			removed = append(removed, p)
+		} else if c, ok := d.compare(ea, eb); ok {
+			c.Path = p
+			changes = append(changes, c)
+		}
+	}
+	var added []string
+	addedByIno := map[wasys.Inode][]string{}
+	for _, p := range d.b.paths {
+		if ea, eb := d.a.entries[p], d.b.entries[p]; ea.n == nil || ea.n.typ != eb.n.typ {
+			added = append(added, p)
+			addedByIno[eb.n.ino] = append(addedByIno[eb.n.ino], p)
+		}
+	}
+
+	// pair removed and added paths of the same inode, the first of hard
+	// links
+	renamedTo := map[string]string{}
+	renamedFrom := map[string]string{}
+	if renames {
+		for _, p := range removed {
+			na := d.a.entries[p].n
+			for i, q := range addedByIno[na.ino] {
+				if d.b.entries[q].n.typ == na.typ {
+					renamedTo[p], renamedFrom[q] = q, p
+					addedByIno[na.ino] = append(addedByIno[na.ino][:i:i], addedByIno[na.ino][i+1:]...)
+					break
+				}
+			}
+		}
+	}
+
+	for _, p := range removed {
+		if renamedTo[p] == "" {
+			changes = append(changes, Change{Kind: Removed, Path: p, Old: d.a.entry(d.a.entries[p])})
+		}
+	}
+	for _, q := range added {
+		p := renamedFrom[q]
+		if p == "" {
+			changes = append(changes, Change{Kind: Added, Path: q, New: d.b.entry(d.b.entries[q])})
+			continue
+		}
+		if parent := path.Dir(p); renamedTo[parent] == path.Dir(q) && path.Base(p) == path.Base(q) {
+			// moved with its directory
+			if c, ok := d.compare(d.a.entries[p], d.b.entries[q]); ok {
+				c.Path, c.OldPath = q, p
+				changes = append(changes, c)
+			}
+			continue
+		}
+		changes = append(changes, Change{
+			Kind:    Renamed,
+			Path:    q,
+			OldPath: p,
+			Old:     d.a.entry(d.a.entries[p]),
+			New:     d.b.entry(d.b.entries[q]),
+		})
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
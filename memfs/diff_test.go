@@ -0,0 +1,103 @@
+package memfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tetratelabs/wazero/experimental/sys"
+)
+
+func newDiffTree(t *testing.T) *MemFS {
+	t.Helper()
+	// a fixed time, so that only the changes made are reported
+	m := New(WithFixedTime(time.Unix(1, 0)))
+	for i, errno := range []sys.Errno{
+		m.WriteFile("a/x", []byte("hello"), 0o644),
+		m.WriteFile("a/y", []byte("world"), 0o644),
+		m.WriteFile("d/sub/z", []byte("zzz"), 0o644),
+		m.WriteFile("t", []byte("type"), 0o644),
+		m.Symlink("a/x", "ln"),
+	} {
+		if errno != 0 {
+			t.Fatalf("step %d: %v", i, errno)
+		}
+	}
+	return m
+}
+
+func TestDiff(t *testing.T) {
+	m := newDiffTree(t)
+	c := m.Clone()
+	if changes := Diff(m, c); len(changes) != 0 {
+		t.Fatalf("changes of an unchanged clone:\n%s", changes)
+	}
+	for i, errno := range []sys.Errno{
+		c.WriteFile("a/x", []byte("HELLO"), 0o644),
+		c.Chmod("a/y", 0o600),
+		c.Rename("a/y", "a/w"),
+		c.Rename("d", "e"),
+		c.WriteFile("e/sub/z", []byte("zz"), 0o644),
+		c.Unlink("t"),
+		c.Mkdir("t", 0o755),
+		c.WriteFile("new", []byte("n"), 0o644),
+		c.Unlink("ln"),
+		c.Symlink("a/w", "ln"),
+	} {
+		if errno != 0 {
+			t.Fatalf("step %d: %v", i, errno)
+		}
+	}
+	want := `renamed  /a/y -> /a/w (mode -rw-r--r-- -> -rw-------)
+modified /a/x (content)
+renamed  /d -> /e
+modified /e/sub/z (from /d/sub/z, size 3 -> 2)
+modified /ln (target a/x -> a/w)
+added    /new (1 bytes)
+removed  /t (4 bytes)
+added    /t (directory)
+`
+	if got := Diff(m, c).String(); got != want {
+		t.Errorf("Diff(m, c) =\n%s\nwant\n%s", got, want)
+	}
+	if c.top.below != m.top.below {
+		t.Error("Diff froze the layer of the clone")
+	}
+
+	// renames are not detected between unrelated trees
+	u := New()
+	if errno := u.WriteFile("a/w", []byte("world"), 0o644); errno != 0 {
+		t.Fatal(errno)
+	}
+	for _, change := range Diff(m, u) {
+		if change.Kind == Renamed {
+			t.Errorf("unrelated trees: %v", change)
+		}
+	}
+}
+
+func TestDiffSiblings(t *testing.T) {
+	m := newDiffTree(t)
+	c1, c2 := m.Clone(), m.Clone()
+	// new files of each clone must not be taken for the same inode
+	for i, errno := range []sys.Errno{
+		c1.WriteFile("p", []byte("one"), 0o644),
+		c2.WriteFile("q", []byte("two"), 0o644),
+		c2.Rename("a/x", "a/v"),
+	} {
+		if errno != 0 {
+			t.Fatalf("step %d: %v", i, errno)
+		}
+	}
+	p, _ := c1.Stat("p")
+	q, _ := c2.Stat("q")
+	if p.Ino == q.Ino {
+		t.Errorf("p and q of sibling clones have the same inode number %d", p.Ino)
+	}
+	want := `renamed  /a/x -> /a/v
+removed  /p (3 bytes)
+added    /q (3 bytes)
+`
+	if got := Diff(c1, c2).String(); got != want {
+		t.Errorf("Diff(c1, c2) =\n%s\nwant\n%s", got, want)
+	}
+}
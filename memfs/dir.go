@@ -1,24 +1,152 @@
 package memfs
 
 import (
+	"io"
+	"sync"
+	"sync/atomic"
+
 	wasys "github.com/tetratelabs/wazero/sys"
 
 	"github.com/tetratelabs/wazero/experimental/sys"
-
-	"github.com/blang/vfs/memfs"
 )
 
 type memoryFSDir struct {
-	fs   *memfs.MemFS
-	path string
+	m   *MemFS
+	ino wasys.Inode
+
+	// mu guards dirents, which are the entries not yet returned by Readdir;
+	// nil means the directory wasn't read yet (or was rewound by Seek).
+	mu      sync.Mutex
+	dirents []sys.Dirent
+	closed  atomic.Bool
 
 	sys.UnimplementedFile
 }
 
+// Dev returns the device ID of the filesystem as defined in sys.File.
+func (f *memoryFSDir) Dev() (uint64, sys.Errno) {
+	return f.m.dev, 0
+}
+
+// Ino returns the inode number as defined in sys.File; it stays the same
+// for the lifetime of the MemFS, also after renames.
+func (f *memoryFSDir) Ino() (wasys.Inode, sys.Errno) {
+	return f.ino, 0
+}
+
 func (f *memoryFSDir) IsDir() (bool, sys.Errno) {
 	return true, 0
 }
 
 func (f *memoryFSDir) Stat() (wasys.Stat_t, sys.Errno) {
-	return stat(f.fs, f.path)
+	if f.closed.Load() {
+		return wasys.Stat_t{}, sys.EBADF
+	}
+	f.m.layerMu.RLock()
+	defer f.m.layerMu.RUnlock()
+	return f.m.statOf(f.m.get(f.ino)), 0
+}
+
+// Seek only supports rewinding the directory, as in os.File.
+func (f *memoryFSDir) Seek(offset int64, whence int) (int64, sys.Errno) {
+	if f.closed.Load() {
+		return 0, sys.EBADF
+	}
+	if offset != 0 || whence != io.SeekStart {
+		return 0, sys.EISDIR
+	}
+	f.mu.Lock()
+	f.dirents = nil
+	f.mu.Unlock()
+	return 0, 0
+}
+
+// Readdir returns the entries sorted by name. The listing is taken on the first
+// call (or the first call after Seek), later calls page through it.
+func (f *memoryFSDir) Readdir(n int) ([]sys.Dirent, sys.Errno) {
+	if f.closed.Load() {
+		return nil, sys.EBADF
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.dirents == nil {
+		f.m.layerMu.RLock()
+		defer f.m.layerMu.RUnlock()
+		n := f.m.get(f.ino)
+		n.mu.RLock()
+		removed := n.nlink == 0
+		if !removed {
+			f.dirents = f.m.dirents(n)
+		}
+		n.mu.RUnlock()
+		if removed {
+			return nil, sys.ENOENT
+		}
+		f.m.accessed(f.ino)
+	}
+
+	if n <= 0 || n > len(f.dirents) {
+		n = len(f.dirents)
+	}
+	dirents := f.dirents[:n:n]
+	f.dirents = f.dirents[n:]
+	return dirents, 0
+}
+
+// Read fails with EISDIR, as defined in sys.File.
+func (f *memoryFSDir) Read([]byte) (int, sys.Errno) {
+	return 0, f.notFile(sys.EISDIR)
+}
+
+// Pread fails with EISDIR, as defined in sys.File.
+func (f *memoryFSDir) Pread([]byte, int64) (int, sys.Errno) {
+	return 0, f.notFile(sys.EISDIR)
+}
+
+// Write fails with EBADF, as defined in sys.File: a directory is never open
+// for writing.
+func (f *memoryFSDir) Write([]byte) (int, sys.Errno) {
+	return 0, sys.EBADF
+}
+
+// Pwrite fails with EISDIR, as defined in sys.File.
+func (f *memoryFSDir) Pwrite([]byte, int64) (int, sys.Errno) {
+	return 0, f.notFile(sys.EISDIR)
+}
+
+// Truncate fails with EISDIR, as defined in sys.File.
+func (f *memoryFSDir) Truncate(int64) sys.Errno {
+	return f.notFile(sys.EISDIR)
+}
+
+// notFile returns errno for an operation on files only, or EBADF once f is
+// closed.
+func (f *memoryFSDir) notFile(errno sys.Errno) sys.Errno {
+	if f.closed.Load() {
+		return sys.EBADF
+	}
+	return errno
+}
+
+// Utimens sets the access and modification times as defined in sys.File.
+// Either can be sys.UTIME_OMIT to keep it.
+func (f *memoryFSDir) Utimens(atim, mtim int64) sys.Errno {
+	if f.closed.Load() {
+		return sys.EBADF
+	}
+	f.m.layerMu.RLock()
+	defer f.m.layerMu.RUnlock()
+	f.m.mut(f.ino).utimens(atim, mtim, f.m.now())
+	return 0
+}
+
+func (f *memoryFSDir) Close() sys.Errno {
+	if !f.closed.Swap(true) {
+		f.m.layerMu.RLock()
+		defer f.m.layerMu.RUnlock()
+		f.m.closed(f.ino)
+	}
+	return 0
 }
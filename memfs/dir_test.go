@@ -0,0 +1,80 @@
+package memfs
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/tetratelabs/wazero/experimental/sys"
+)
+
+func TestReaddir(t *testing.T) {
+	m := New()
+	for i, errno := range []sys.Errno{
+		m.WriteFile("d/c", nil, 0o644),
+		m.Mkdir("d/a", 0o755),
+		m.Symlink("c", "d/b"),
+		m.WriteFile("d/e", nil, 0o644),
+	} {
+		if errno != 0 {
+			t.Fatalf("step %d: %v", i, errno)
+		}
+	}
+	f, errno := m.OpenFile("d", sys.O_RDONLY, 0)
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	defer f.Close()
+
+	var names []string
+	for _, want := range []int{3, 1, 0} {
+		dirents, errno := f.Readdir(3)
+		if errno != 0 || len(dirents) != want {
+			t.Fatalf("Readdir(3) = %v, %v; want %d entries", dirents, errno, want)
+		}
+		for _, d := range dirents {
+			names = append(names, d.Name)
+		}
+	}
+	if got := fmt.Sprint(names); got != "[a b c e]" {
+		t.Errorf("entries %s, want them sorted", got)
+	}
+
+	// rewound, and listed again with the changes made meanwhile
+	if errno := m.Unlink("d/e"); errno != 0 {
+		t.Fatal(errno)
+	}
+	if _, errno := f.Seek(0, io.SeekStart); errno != 0 {
+		t.Fatal(errno)
+	}
+	if _, errno := f.Seek(1, io.SeekStart); errno != sys.EISDIR {
+		t.Errorf("Seek(1) of a directory = %v, want EISDIR", errno)
+	}
+	dirents, errno := f.Readdir(-1)
+	if errno != 0 || len(dirents) != 3 {
+		t.Fatalf("Readdir(-1) after rewinding = %v, %v", dirents, errno)
+	}
+	for i, want := range []fs.FileMode{fs.ModeDir, fs.ModeSymlink, 0} {
+		d := dirents[i]
+		st, _ := m.Lstat("d/" + d.Name)
+		if d.Type != want || d.Ino != st.Ino || d.Ino == 0 {
+			t.Errorf("%s has type %v and ino %d, want %v and %d", d.Name, d.Type, d.Ino, want, st.Ino)
+		}
+	}
+
+	a, errno := m.OpenFile("d", sys.O_RDONLY, 0)
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	if errno := m.RemoveAll("d"); errno != 0 {
+		t.Fatal(errno)
+	}
+	if _, errno := a.Readdir(-1); errno != sys.ENOENT {
+		t.Errorf("Readdir of a removed directory = %v, want ENOENT", errno)
+	}
+	a.Close()
+	if _, errno := a.Readdir(-1); errno != sys.EBADF {
+		t.Errorf("Readdir of a closed directory = %v, want EBADF", errno)
+	}
+}
@@ -0,0 +1,286 @@
+package memfs
+
+import (
+	"io/fs"
+	"sync"
+	"sync/atomic"
+
+	wasys "github.com/tetratelabs/wazero/sys"
+
+	"github.com/tetratelabs/wazero/experimental/sys"
+)
+
+// pipeSize is the capacity of a FIFO, and pipeBuf the largest write that is
+// never interleaved with others; same as Linux.
+const (
+	pipeSize = 64 << 10
+	pipeBuf  = 4 << 10
+)
+
+// Mkfifo creates a FIFO (named pipe) at path with perm, as mkfifo(3), so that
+// modules running at the same time can stream data to each other through the
+// MemFS.
+//
+// As in POSIX, opening a FIFO for reading blocks until it is opened for
+// writing, and the other way around, unless it is opened with O_RDWR or
+// O_NONBLOCK; with O_NONBLOCK, opening it for writing without a reader fails
+// with EAGAIN (POSIX ENXIO, which wazero doesn't have). A read blocks until
+// there is data, and reads as the end of file once all writers are closed;
+// a write blocks while the FIFO is full, and fails with EIO (POSIX EPIPE,
+// which wazero doesn't have) once all readers are closed. With O_NONBLOCK,
+// reads and writes that would block fail with EAGAIN instead. Seek, Pread and
+// Pwrite fail with ENOSYS.
+//
+// Data in a FIFO is not part of the tree: a Clone gets the FIFO empty and
+// unopened, and the data left is discarded once the FIFO is no longer open.
+func (m *MemFS) Mkfifo(path string, perm fs.FileMode) sys.Errno {
+	m.layerMu.RLock()
+	defer m.layerMu.RUnlock()
+
+	dir, name, n, errno := m.lookup(path, false)
+	if errno != 0 {
+		return errno
+	}
+	if n != nil {
+		return sys.EEXIST
+	}
+	if dirPath(path) {
+		return sys.ENOENT
+	}
+	n = m.newInode(fs.ModeNamedPipe | perm&fs.ModePerm)
+	existing, errno := m.addNewEntry(dir, name, n)
+	if errno != 0 {
+		return errno
+	}
+	if existing != nil {
+		return sys.EEXIST
+	}
+	m.notify(Create, path, n.ino)
+	return 0
+}
+
+// pipe is the state of an open FIFO, which all its opened files share.
+type pipe struct {
+	mu sync.Mutex
+	// cond is signaled whenever any field below changes.
+	cond sync.Cond
+	buf  []byte
+	// readers and writers count the files open for reading and writing;
+	// readerOpens and writerOpens count all that were ever opened, so that a
+	// blocked open sees a peer even if it is closed right away.
+	readers, writers         int
+	readerOpens, writerOpens uint64
+}
+
+// openPipe opens the FIFO ino with flag, not waiting for a peer.
+func (m *MemFS) openPipe(ino wasys.Inode, flag sys.Oflag) (*memoryFSFifo, sys.Errno) {
+	f := &memoryFSFifo{m: m, ino: ino, flag: flag}
+	f.nonblock.Store(flag&sys.O_NONBLOCK != 0)
+
+	m.pipesMu.Lock()
+	defer m.pipesMu.Unlock()
+	p := m.pipes[ino]
+	if p == nil {
+		p = &pipe{}
+		p.cond.L = &p.mu
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !f.readable() && p.readers == 0 && f.nonblock.Load() {
+		// it should be POSIX ENXIO, which wazero doesn't have
+		return nil, sys.EAGAIN
+	}
+	if f.readable() {
+		p.readers++
+		p.readerOpens++
+	}
+	if f.writable() {
+		p.writers++
+		p.writerOpens++
+	}
+	if m.pipes == nil {
+		m.pipes = map[wasys.Inode]*pipe{}
+	}
+	m.pipes[ino] = p
+	f.p = p
+	p.cond.Broadcast()
+	return f, 0
+}
+
+// waitPeer waits until f has a peer, as a blocking open does.
+func (f *memoryFSFifo) waitPeer() {
+	if f.nonblock.Load() || f.readable() && f.writable() {
+		return
+	}
+	p := f.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if f.readable() {
+		for opens := p.writerOpens; p.writers == 0 && p.writerOpens == opens; {
+			p.cond.Wait()
+		}
+	} else {
+		for opens := p.readerOpens; p.readers == 0 && p.readerOpens == opens; {
+			p.cond.Wait()
+		}
+	}
+}
+
+// memoryFSFifo is an opened FIFO. Reads and writes only lock the pipe, so
+// blocking doesn't block Clone or any other operation.
+type memoryFSFifo struct {
+	m        *MemFS
+	ino      wasys.Inode
+	flag     sys.Oflag
+	p        *pipe
+	nonblock atomic.Bool
+	closed   atomic.Bool
+
+	sys.UnimplementedFile
+}
+
+func (f *memoryFSFifo) readable() bool {
+	return f.flag&(sys.O_RDONLY|sys.O_RDWR|sys.O_WRONLY) != sys.O_WRONLY
+}
+
+func (f *memoryFSFifo) writable() bool {
+	return f.flag&(sys.O_RDONLY|sys.O_RDWR|sys.O_WRONLY) != sys.O_RDONLY
+}
+
+func (f *memoryFSFifo) Stat() (wasys.Stat_t, sys.Errno) {
+	if f.closed.Load() {
+		return wasys.Stat_t{}, sys.EBADF
+	}
+	f.m.layerMu.RLock()
+	defer f.m.layerMu.RUnlock()
+	return f.m.statOf(f.m.get(f.ino)), 0
+}
+
+func (f *memoryFSFifo) Close() sys.Errno {
+	if f.closed.Swap(true) {
+		return 0
+	}
+	m, p := f.m, f.p
+	m.pipesMu.Lock()
+	p.mu.Lock()
+	if f.readable() {
+		p.readers--
+	}
+	if f.writable() {
+		p.writers--
+	}
+	if p.readers == 0 && p.writers == 0 {
+		// the data left is discarded
+		delete(m.pipes, f.ino)
+	}
+	p.cond.Broadcast()
+	p.mu.Unlock()
+	m.pipesMu.Unlock()
+
+	m.layerMu.RLock()
+	defer m.layerMu.RUnlock()
+	m.closed(f.ino)
+	return 0
+}
+
+// Dev returns the device ID of the filesystem as defined in sys.File.
+func (f *memoryFSFifo) Dev() (uint64, sys.Errno) {
+	return f.m.dev, 0
+}
+
+// Ino returns the inode number as defined in sys.File.
+func (f *memoryFSFifo) Ino() (wasys.Inode, sys.Errno) {
+	return f.ino, 0
+}
+
+func (f *memoryFSFifo) IsDir() (bool, sys.Errno) {
+	return false, 0
+}
+
+// IsNonblock returns whether reads and writes fail with EAGAIN instead of
+// blocking. wazero only calls it on its own files, so only O_NONBLOCK at open
+// reaches it from a guest.
+func (f *memoryFSFifo) IsNonblock() bool {
+	return f.nonblock.Load()
+}
+
+// SetNonblock toggles the non-blocking mode, as fcntl with O_NONBLOCK. As
+// IsNonblock, it is for the host only.
+func (f *memoryFSFifo) SetNonblock(enable bool) sys.Errno {
+	if f.closed.Load() {
+		return sys.EBADF
+	}
+	f.nonblock.Store(enable)
+	return 0
+}
+
+func (f *memoryFSFifo) Read(buf []byte) (int, sys.Errno) {
+	if f.closed.Load() || !f.readable() {
+		return 0, sys.EBADF
+	}
+	if len(buf) == 0 {
+		return 0, 0
+	}
+	p := f.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.buf) == 0 {
+		switch {
+		case p.writers == 0:
+			return 0, 0
+		case f.nonblock.Load():
+			return 0, sys.EAGAIN
+		case f.closed.Load():
+			return 0, sys.EBADF
+		}
+		p.cond.Wait()
+	}
+
+	n := copy(buf, p.buf)
+	p.buf = p.buf[n:]
+	if len(p.buf) == 0 {
+		p.buf = nil
+	}
+	p.cond.Broadcast()
+	return n, 0
+}
+
+func (f *memoryFSFifo) Write(buf []byte) (int, sys.Errno) {
+	if f.closed.Load() || !f.writable() {
+		return 0, sys.EBADF
+	}
+	p := f.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	written := 0
+	for written < len(buf) {
+		if p.readers == 0 {
+			if written > 0 {
+				return written, 0
+			}
+			// it should be POSIX EPIPE, which wazero doesn't have
+			return 0, sys.EIO
+		}
+		space := pipeSize - len(p.buf)
+		if rest := len(buf) - written; space == 0 || rest <= pipeBuf && space < rest {
+			// full, or a small write that must not be split
+			switch {
+			case f.nonblock.Load() && written > 0:
+				return written, 0
+			case f.nonblock.Load():
+				return 0, sys.EAGAIN
+			case f.closed.Load():
+				return written, sys.EBADF
+			}
+			p.cond.Wait()
+			continue
+		}
+		n := min(space, len(buf)-written)
+		p.buf = append(p.buf, buf[written:written+n]...)
+		written += n
+		p.cond.Broadcast()
+	}
+	return written, 0
+}
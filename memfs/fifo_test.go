@@ -0,0 +1,97 @@
+package memfs
+
+import (
+	"bytes"
+	"io/fs"
+	"math/rand"
+	"testing"
+
+	"github.com/tetratelabs/wazero/experimental/sys"
+)
+
+func TestFifo(t *testing.T) {
+	m := New()
+	if errno := m.Mkfifo("p", 0o644); errno != 0 {
+		t.Fatal(errno)
+	}
+	if _, errno := m.OpenFile("p", sys.O_WRONLY|sys.O_NONBLOCK, 0); errno != sys.EAGAIN {
+		t.Errorf("OpenFile(p, O_WRONLY|O_NONBLOCK) without reader = %v, want EAGAIN", errno)
+	}
+
+	// more than the capacity, in writes larger than pipeBuf
+	want := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(want)
+	done := make(chan []byte)
+	go func() {
+		r, errno := m.OpenFile("p", sys.O_RDONLY, 0)
+		if errno != 0 {
+			t.Error(errno)
+			close(done)
+			return
+		}
+		defer r.Close()
+		var got []byte
+		buf := make([]byte, 1000)
+		for {
+			count, errno := r.Read(buf)
+			if errno != 0 {
+				t.Error(errno)
+			}
+			if count == 0 {
+				break
+			}
+			got = append(got, buf[:count]...)
+		}
+		done <- got
+	}()
+	// opens and I/O don't hold up Clone
+	c := m.Clone()
+	w, errno := m.OpenFile("p", sys.O_WRONLY, 0)
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	for off := 0; off < len(want); off += 7777 {
+		if _, errno := w.Write(want[off:min(len(want), off+7777)]); errno != 0 {
+			t.Fatal(errno)
+		}
+		if off == 7777*3 {
+			c.Clone()
+		}
+	}
+	w.Close()
+	if got := <-done; !bytes.Equal(got, want) {
+		t.Errorf("read %d bytes, want the %d written", len(got), len(want))
+	}
+	if st, errno := c.Stat("p"); errno != 0 || st.Mode.Type() != fs.ModeNamedPipe {
+		t.Errorf("p of the clone has mode %v, %v", st.Mode, errno)
+	}
+
+	w, errno = m.OpenFile("p", sys.O_RDWR, 0)
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	r, errno := m.OpenFile("p", sys.O_RDONLY|sys.O_NONBLOCK, 0)
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	buf := make([]byte, 4)
+	if _, errno := r.Read(buf); errno != sys.EAGAIN {
+		t.Errorf("Read of an empty FIFO with O_NONBLOCK = %v, want EAGAIN", errno)
+	}
+	w.Close()
+	if count, errno := r.Read(buf); count != 0 || errno != 0 {
+		t.Errorf("Read without writer = %d, %v, want the end of file", count, errno)
+	}
+	w, errno = m.OpenFile("p", sys.O_WRONLY, 0)
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	r.Close()
+	if _, errno := w.Write(buf); errno != sys.EIO {
+		t.Errorf("Write without reader = %v, want EIO", errno)
+	}
+	w.Close()
+	if len(m.pipes) != 0 {
+		t.Errorf("%d pipes left once closed", len(m.pipes))
+	}
+}
@@ -1,80 +1,253 @@
 package memfs
 
 import (
-	"errors"
 	"io"
-	"strings"
+	"sync"
+	"sync/atomic"
 
 	wasys "github.com/tetratelabs/wazero/sys"
 
 	"github.com/tetratelabs/wazero/experimental/sys"
-
-	"github.com/blang/vfs"
-	"github.com/blang/vfs/memfs"
 )
 
 type memoryFSFile struct {
-	fs   *memfs.MemFS
-	fl   vfs.File
+	m   *MemFS
+	ino wasys.Inode
+	// path is the path the file was opened with, for the events; see Watch.
 	path string
+	flag sys.Oflag
+
+	// mu guards offset, so that concurrent Reads and Writes each get their
+	// own part of the file.
+	mu     sync.Mutex
+	offset int64
+	closed atomic.Bool
+	// append is set by O_APPEND or SetAppend.
+	append atomic.Bool
+	// written is set once data is written, see WithBlobStore.
+	written atomic.Bool
 
 	sys.UnimplementedFile
 }
 
 func (f *memoryFSFile) Stat() (wasys.Stat_t, sys.Errno) {
-	return stat(f.fs, f.path)
+	if f.closed.Load() {
+		return wasys.Stat_t{}, sys.EBADF
+	}
+	f.m.layerMu.RLock()
+	defer f.m.layerMu.RUnlock()
+	return f.m.statOf(f.m.get(f.ino)), 0
 }
 
 func (f *memoryFSFile) Close() sys.Errno {
-	err := f.fl.Close()
-	if err != nil {
-		// this will never happen
-		return sys.EIO
+	if !f.closed.Swap(true) {
+		f.m.layerMu.RLock()
+		defer f.m.layerMu.RUnlock()
+		if f.written.Load() {
+			f.m.dedup(f.ino)
+		}
+		f.m.closed(f.ino)
+		if f.writable() {
+			f.m.notify(CloseWrite, f.path, f.ino)
+		}
 	}
 	return 0
 }
 
+// Dev returns the device ID of the filesystem as defined in sys.File.
+func (f *memoryFSFile) Dev() (uint64, sys.Errno) {
+	return f.m.dev, 0
+}
+
+// Ino returns the inode number as defined in sys.File; it stays the same
+// for the lifetime of the MemFS, also after renames.
+func (f *memoryFSFile) Ino() (wasys.Inode, sys.Errno) {
+	return f.ino, 0
+}
+
 func (f *memoryFSFile) IsDir() (bool, sys.Errno) {
 	return false, 0
 }
 
 func (f *memoryFSFile) Read(buf []byte) (n int, errno sys.Errno) {
-	n, err := f.fl.Read(buf)
-	if err != nil {
-		if errors.Is(err, io.EOF) {
-			return n, 0
-		}
-		// this will never happen
+	if f.closed.Load() || !f.readable() {
 		return 0, sys.EBADF
 	}
-	return
+	f.m.layerMu.RLock()
+	defer f.m.layerMu.RUnlock()
+	f.mu.Lock()
+	n, errno = f.m.get(f.ino).readAt(buf, f.offset)
+	f.offset += int64(n)
+	f.mu.Unlock()
+	f.m.accessed(f.ino)
+	return n, errno
 }
 
 func (f *memoryFSFile) Seek(offset int64, whence int) (newOffset int64, errno sys.Errno) {
-	r, err := f.fl.Seek(offset, whence)
-	if err != nil {
+	if f.closed.Load() {
+		return 0, sys.EBADF
+	}
+	f.m.layerMu.RLock()
+	defer f.m.layerMu.RUnlock()
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
-		if strings.Contains(err.Error(), "invalid whence") {
-			return 0, sys.EINVAL
-		}
-		if strings.Contains(err.Error(), "negative position") {
-			return 0, sys.EINVAL
-		}
-		if strings.Contains(err.Error(), "too far") {
-			// it should be POSIX EFBIG but wazero maps that to EIO
-			return 0, sys.EIO
-		}
-		// can never happen
+	switch whence {
+	case io.SeekStart:
+		newOffset = offset
+	case io.SeekCurrent:
+		newOffset = f.offset + offset
+	case io.SeekEnd:
+		n := f.m.get(f.ino)
+		n.mu.RLock()
+		newOffset = n.size() + offset
+		n.mu.RUnlock()
+	default:
 		return 0, sys.EINVAL
 	}
-	return r, 0
+	if newOffset < 0 {
+		return 0, sys.EINVAL
+	}
+	// seeking past the end is fine; a later write leaves a hole
+	f.offset = newOffset
+	return newOffset, 0
 }
 
 func (f *memoryFSFile) Write(buf []byte) (n int, errno sys.Errno) {
-	n, err := f.fl.Write(buf)
-	if err != nil {
-		// it should be POSIX EFBIG but wazero maps that to EIO
-		return 0, sys.EIO
+	if f.closed.Load() || !f.writable() {
+		return 0, sys.EBADF
+	}
+	f.m.layerMu.RLock()
+	defer f.m.layerMu.RUnlock()
+	node := f.m.mut(f.ino)
+	f.mu.Lock()
+	if f.append.Load() {
+		var off int64
+		if off, n, errno = node.writeEnd(&f.m.quota, f.m.spill, buf); n > 0 {
+			f.offset = off
+		}
+	} else {
+		n, errno = node.writeAt(&f.m.quota, f.m.spill, buf, f.offset)
+	}
+	f.offset += int64(n)
+	f.mu.Unlock()
+	if n > 0 {
+		f.written.Store(true)
+		node.modified(f.m.now())
+		f.m.notify(Write, f.path, f.ino)
 	}
 	return
 }
+
+// IsAppend returns whether writes go to the end of the file, as defined in
+// sys.File.
+func (f *memoryFSFile) IsAppend() bool {
+	return f.append.Load()
+}
+
+// SetAppend toggles the append mode as defined in sys.File; the file offset
+// is kept as is.
+func (f *memoryFSFile) SetAppend(enable bool) sys.Errno {
+	if f.closed.Load() {
+		return sys.EBADF
+	}
+	f.append.Store(enable)
+	return 0
+}
+
+func (f *memoryFSFile) readable() bool {
+	return f.flag&(sys.O_RDONLY|sys.O_RDWR|sys.O_WRONLY) != sys.O_WRONLY
+}
+
+func (f *memoryFSFile) writable() bool {
+	return f.flag&(sys.O_RDONLY|sys.O_RDWR|sys.O_WRONLY) != sys.O_RDONLY
+}
+
+func (f *memoryFSFile) Pread(buf []byte, off int64) (n int, errno sys.Errno) {
+	if f.closed.Load() || !f.readable() {
+		return 0, sys.EBADF
+	}
+	if off < 0 {
+		return 0, sys.EINVAL
+	}
+	f.m.layerMu.RLock()
+	defer f.m.layerMu.RUnlock()
+	n, errno = f.m.get(f.ino).readAt(buf, off)
+	f.m.accessed(f.ino)
+	return n, errno
+}
+
+func (f *memoryFSFile) Pwrite(buf []byte, off int64) (n int, errno sys.Errno) {
+	if f.closed.Load() || !f.writable() {
+		return 0, sys.EBADF
+	}
+	if off < 0 {
+		return 0, sys.EINVAL
+	}
+	f.m.layerMu.RLock()
+	defer f.m.layerMu.RUnlock()
+	node := f.m.mut(f.ino)
+	if f.append.Load() {
+		// ignoring off, as Linux does
+		_, n, errno = node.writeEnd(&f.m.quota, f.m.spill, buf)
+	} else {
+		n, errno = node.writeAt(&f.m.quota, f.m.spill, buf, off)
+	}
+	if n > 0 {
+		f.written.Store(true)
+		node.modified(f.m.now())
+		f.m.notify(Write, f.path, f.ino)
+	}
+	return
+}
+
+// Truncate changes the size of the file; the file offset is kept as is, even
+// past the new end.
+func (f *memoryFSFile) Truncate(size int64) sys.Errno {
+	if f.closed.Load() || !f.writable() {
+		return sys.EBADF
+	}
+	if size < 0 {
+		return sys.EINVAL
+	}
+	f.m.layerMu.RLock()
+	defer f.m.layerMu.RUnlock()
+	n := f.m.mut(f.ino)
+	if errno := n.truncate(&f.m.quota, size); errno != 0 {
+		return errno
+	}
+	n.modified(f.m.now())
+	f.m.notify(Truncate, f.path, f.ino)
+	return 0
+}
+
+// Utimens sets the access and modification times as defined in sys.File.
+// Either can be sys.UTIME_OMIT to keep it.
+func (f *memoryFSFile) Utimens(atim, mtim int64) sys.Errno {
+	if f.closed.Load() {
+		return sys.EBADF
+	}
+	f.m.layerMu.RLock()
+	defer f.m.layerMu.RUnlock()
+	f.m.mut(f.ino).utimens(atim, mtim, f.m.now())
+	return 0
+}
+
+// Readdir fails with EBADF, as defined in sys.File for anything but a
+// directory.
+func (f *memoryFSFile) Readdir(int) ([]sys.Dirent, sys.Errno) {
+	return nil, sys.EBADF
+}
+
+// Sync is a no-op, there is nothing to flush.
+func (f *memoryFSFile) Sync() sys.Errno {
+	if f.closed.Load() {
+		return sys.EBADF
+	}
+	return 0
+}
+
+// Datasync is a no-op, there is nothing to flush.
+func (f *memoryFSFile) Datasync() sys.Errno {
+	return f.Sync()
+}
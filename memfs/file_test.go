@@ -0,0 +1,74 @@
+package memfs
+
+import (
+	"io"
+	"testing"
+
+	"github.com/tetratelabs/wazero/experimental/sys"
+)
+
+func TestPositional(t *testing.T) {
+	m := New()
+	f, errno := m.OpenFile("f", sys.O_RDWR|sys.O_CREAT, 0o644)
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	defer f.Close()
+	if _, errno := f.Write([]byte("hello")); errno != 0 {
+		t.Fatal(errno)
+	}
+
+	// none of them moves the offset, left at 5
+	buf := make([]byte, 3)
+	if count, errno := f.Pread(buf, 1); count != 3 || errno != 0 || string(buf) != "ell" {
+		t.Errorf("Pread(1) = %d, %v, %q", count, errno, buf)
+	}
+	if count, errno := f.Pwrite([]byte("XY"), 8); count != 2 || errno != 0 {
+		t.Errorf("Pwrite(8) = %d, %v", count, errno)
+	}
+	if count, errno := f.Pread(buf, 9); count != 1 || errno != 0 || buf[0] != 'Y' {
+		t.Errorf("Pread(9) = %d, %v, %q", count, errno, buf[:count])
+	}
+	if count, errno := f.Pread(buf, 100); count != 0 || errno != 0 {
+		t.Errorf("Pread past the end = %d, %v", count, errno)
+	}
+	if errno := f.Truncate(2); errno != 0 {
+		t.Fatal(errno)
+	}
+	if off, _ := f.Seek(0, io.SeekCurrent); off != 5 {
+		t.Errorf("offset %d, want 5", off)
+	}
+	// past the end, so the gap reads as zeros
+	if _, errno := f.Write([]byte("!")); errno != 0 {
+		t.Fatal(errno)
+	}
+	if got := readString(t, m, "f"); got != "he\x00\x00\x00!" {
+		t.Errorf("f is %q", got)
+	}
+
+	for _, c := range []struct {
+		op string
+		fn func() sys.Errno
+	}{
+		{"Pread", func() sys.Errno { _, errno := f.Pread(buf, -1); return errno }},
+		{"Pwrite", func() sys.Errno { _, errno := f.Pwrite(buf, -1); return errno }},
+		{"Truncate", func() sys.Errno { return f.Truncate(-1) }},
+		{"Seek", func() sys.Errno { _, errno := f.Seek(-1, io.SeekStart); return errno }},
+	} {
+		if errno := c.fn(); errno != sys.EINVAL {
+			t.Errorf("%s at a negative offset = %v, want EINVAL", c.op, errno)
+		}
+	}
+
+	r, errno := m.OpenFile("f", sys.O_RDONLY, 0)
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	defer r.Close()
+	if _, errno := r.Pwrite(buf, 0); errno != sys.EBADF {
+		t.Errorf("Pwrite of a read-only file = %v, want EBADF", errno)
+	}
+	if errno := r.Truncate(0); errno != sys.EBADF {
+		t.Errorf("Truncate of a read-only file = %v, want EBADF", errno)
+	}
+}
@@ -1,165 +1,697 @@
 // memfs implements a simple fake memory FS for Wazero.
 //
-// The actual implementation of the FS is from github.com/blang/vfs/memfs,
-// this package is just wrapping that for Wazero.
+// The whole tree lives in this package: directories, regular files, symlinks
+// and hard links are inodes held in memory, and all operations return the
+// sys.Errno a POSIX filesystem would.
 //
-// It implements only very small number of functions, because only those were needed
-// for my purposes (that is, running ghostscript with WASI), as ghostscript only calls these.
+// It started as a wrapper around github.com/blang/vfs/memfs for running
+// ghostscript with WASI, so some less common functions may still be missing.
 //
 // Feel free to make a PR if you need to implement some other functions.
 package memfs
 
 import (
-	"errors"
 	"io/fs"
-	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	wasys "github.com/tetratelabs/wazero/sys"
 
-	"os"
-
 	"github.com/tetratelabs/wazero/experimental/sys"
-
-	"github.com/blang/vfs/memfs"
 )
 
+// defaultDev is the device ID of a MemFS created by New, the same for all so
+// that runs against a fresh MemFS report the same Stat_t; see WithDev.
+const defaultDev = 1
+
+// lastClone counts the device IDs given by Clone, above defaultDev, so that
+// files of a MemFS and of its clones never compare equal by (dev, ino).
+var lastClone atomic.Uint64
+
 // New creates a new memory filesystem
-func New() *MemFS {
-	mfs := memfs.Create()
-	mmfs := &MemFS{fs: mfs}
+func New(opts ...Option) *MemFS {
+	mmfs := &MemFS{
+		top:     newLayer(nil),
+		opens:   map[wasys.Inode]int{},
+		clock:   time.Now,
+		dev:     defaultDev,
+		lastIno: new(atomic.Uint64),
+	}
+	for _, opt := range opts {
+		opt(mmfs)
+	}
+	root := mmfs.newInode(fs.ModeDir | 0o777)
+	root.nlink = 1
+	mmfs.root = root.ino
+	mmfs.top.inodes[root.ino] = root
+	mmfs.quota.inodes.Store(1)
 	return mmfs
 }
 
-// WriteFile is a helper function that writes a content to a file.
-// Errors have the same semantics as wazero errors
-func (m *MemFS) WriteFile(path string, content []byte) sys.Errno {
-	f, err := m.OpenFile(path, sys.O_WRONLY|sys.O_CREAT, 0)
-	if err != 0 {
-		return err
+// MemFS is a memory-only wazero filesystem, implementing all of sys.FS, and
+// all of sys.File on the files it opens.
+//
+// MemFS and the files it opens are safe for concurrent use, so one MemFS can be
+// shared by many module instances running at the same time. Locking is per
+// inode, so operations on unrelated files don't wait on each other. A file
+// opened once should still not be used from more goroutines at once, as the
+// file offset is shared; in particular, concurrent Reads or Writes on the same
+// sys.File are safe, but their order is unspecified.
+type MemFS struct {
+	root wasys.Inode
+	// lastIno is the last inode number given, shared by all clones of the
+	// same MemFS, so that they never give the same number to different
+	// inodes.
+	lastIno *atomic.Uint64
+
+	// layerMu is held for reading by every operation and for writing by
+	// Clone, so that no inode changes while its layer is being frozen.
+	layerMu sync.RWMutex
+	top     *layer
+
+	// renameMu serializes renames, so that directories don't move while
+	// a rename checks it doesn't move a directory into itself. With
+	// Limits.MaxDepth, Mkdir holds it for reading, so that no directory is
+	// added to a tree being moved.
+	renameMu sync.RWMutex
+
+	// opensMu guards opens, the count of open files of each inode.
+	opensMu sync.Mutex
+	opens   map[wasys.Inode]int
+
+	// pipesMu guards pipes, the state of each open FIFO; see Mkfifo. It is
+	// locked before a pipe.
+	pipesMu sync.Mutex
+	pipes   map[wasys.Inode]*pipe
+
+	// watchMu guards watchers; see Watch.
+	watchMu  sync.RWMutex
+	watchers []*Watcher
+
+	dev       uint64
+	clock     func() time.Time
+	checkPerm bool
+	quota     quota
+	spill     *spill
+	blobs     *BlobStore
+
+	sys.UnimplementedFS
+}
+
+// OpenFile opens a file as defined in sys.FS, with the semantics of Linux:
+//   - O_DIRECTORY fails with ENOTDIR on anything but a directory, and with
+//     EINVAL together with O_CREAT.
+//   - A directory can only be opened read-only, without O_CREAT or O_TRUNC;
+//     otherwise it fails with EISDIR.
+//   - O_NOFOLLOW fails with ELOOP if path is a symlink.
+//   - A path ending with a slash can only open a directory: it fails with
+//     ENOTDIR on anything else, and with EISDIR if missing with O_CREAT.
+//   - Opening a FIFO may block, see Mkfifo.
+//   - O_TRUNC truncates even with O_RDONLY, if the file is writable.
+//   - With O_APPEND, every Write and Pwrite goes to the end of the file, and
+//     moves the offset there; reads still start at 0. See also SetAppend.
+//   - O_EXCL without O_CREAT is ignored; the other flags are accepted and
+//     ignored.
+func (m *MemFS) OpenFile(path string, flag sys.Oflag, perm fs.FileMode) (sys.File, sys.Errno) {
+	f, errno := m.openFile(path, flag, perm)
+	if fifo, ok := f.(*memoryFSFifo); ok {
+		// not under layerMu, so that waiting doesn't block Clone
+		fifo.waitPeer()
+	}
+	return f, errno
+}
+
+func (m *MemFS) openFile(path string, flag sys.Oflag, perm fs.FileMode) (sys.File, sys.Errno) {
+	m.layerMu.RLock()
+	defer m.layerMu.RUnlock()
+
+	if flag&(sys.O_CREAT|sys.O_DIRECTORY) == sys.O_CREAT|sys.O_DIRECTORY {
+		// as Linux since 6.4; it used to create a regular file, then fail
+		return nil, sys.EINVAL
+	}
+	excl := flag&(sys.O_CREAT|sys.O_EXCL) == sys.O_CREAT|sys.O_EXCL
+	// O_CREAT|O_EXCL fails on an existing symlink, even a dangling one
+	follow := flag&sys.O_NOFOLLOW == 0 && !excl
+
+	var n *inode
+	for n == nil {
+		dir, name, existing, errno := m.lookup(path, follow)
+		if errno != 0 {
+			return nil, errno
+		}
+
+		if existing == nil {
+			if flag&sys.O_CREAT == 0 {
+				return nil, sys.ENOENT
+			}
+			if dirPath(path) {
+				return nil, sys.EISDIR
+			}
+			// the creating open is allowed regardless of perm, as in POSIX
+			n = m.newInode(perm & fs.ModePerm)
+			if existing, errno = m.addNewEntry(dir, name, n); errno != 0 {
+				return nil, errno
+			}
+			if existing == nil {
+				if !m.opened(n.ino) {
+					return nil, sys.ENOENT
+				}
+				m.notify(Create, path, n.ino)
+				return m.newFile(n.ino, path, flag), 0
+			}
+			// created meanwhile by someone else
+			n = nil
+			if existing.isSymlink() && follow {
+				continue
+			}
+		}
+
+		if excl {
+			return nil, sys.EEXIST
+		}
+		if errno = m.accessOflag(existing, flag); errno != 0 {
+			return nil, errno
+		}
+		n = existing
+	}
+
+	if n.isSymlink() {
+		// can only happen with O_NOFOLLOW
+		return nil, sys.ELOOP
+	}
+	if n.isDir() {
+		if flag&(sys.O_RDWR|sys.O_WRONLY|sys.O_CREAT|sys.O_TRUNC) != 0 {
+			return nil, sys.EISDIR
+		}
+		if !m.opened(n.ino) {
+			return nil, sys.ENOENT
+		}
+		// return directory as a different type
+		dir := &memoryFSDir{m: m, ino: n.ino}
+		return dir, 0
+	}
+
+	if flag&sys.O_DIRECTORY != 0 {
+		return nil, sys.ENOTDIR
 	}
 
-	_, err = f.Write(content)
-	return err
+	if !m.opened(n.ino) {
+		// removed after the lookup
+		return nil, sys.ENOENT
+	}
+	switch {
+	case n.isDevice():
+		// O_TRUNC is ignored, as on Linux
+		return &memoryFSDevice{m: m, ino: n.ino, flag: flag, dev: n.device}, 0
+	case n.isFifo():
+		f, errno := m.openPipe(n.ino, flag)
+		if errno != 0 {
+			m.closed(n.ino)
+			return nil, errno
+		}
+		return f, 0
+	}
+	if flag&sys.O_TRUNC != 0 {
+		// also with O_RDONLY, as on Linux; write permission is still needed
+		n = m.mut(n.ino)
+		_ = n.truncate(&m.quota, 0)
+		n.modified(m.now())
+		m.notify(Truncate, path, n.ino)
+	}
+	return m.newFile(n.ino, path, flag), 0
+}
+
+// newFile returns the regular file ino opened at path. Even with O_APPEND,
+// the offset starts at 0, so that reads start at the beginning.
+func (m *MemFS) newFile(ino wasys.Inode, path string, flag sys.Oflag) *memoryFSFile {
+	f := &memoryFSFile{ino: ino, path: path, flag: flag, m: m}
+	f.append.Store(flag&sys.O_APPEND != 0)
+	return f
 }
 
-// ReadFile is a helper function that returns a content of a file.
-// Errors have the same semantics as wazero errors
-func (m *MemFS) ReadFile(path string) ([]byte, sys.Errno) {
-	f, err := m.OpenFile(path, sys.O_RDONLY, 0)
-	if err != 0 {
-		return nil, err
+// lockDir returns the directory dir of a lookup, ready for changes and locked
+// for writing, checking it wasn't removed after the lookup. The returned
+// directory must be unlocked by the caller, unless errno is returned.
+func (m *MemFS) lockDir(dir *inode) (*inode, sys.Errno) {
+	if dir = m.mut(dir.ino); dir == nil {
+		return nil, sys.ENOENT
+	}
+	dir.mu.Lock()
+	if dir.nlink == 0 {
+		dir.mu.Unlock()
+		return nil, sys.ENOENT
 	}
+	return dir, 0
+}
 
-	st, errno := f.Stat()
-	if errno != 0 {
+// addEntry links n as name in dir, unless there already is such entry, which
+// is returned instead. n must be new or returned by mut; if it is being
+// released, addEntry fails with ENOENT.
+func (m *MemFS) addEntry(dir *inode, name string, n *inode, isNew bool) (existing *inode, errno sys.Errno) {
+	if len(name) > maxNameLen {
+		return nil, sys.ENAMETOOLONG
+	}
+	if dir, errno = m.lockDir(dir); errno != 0 {
 		return nil, errno
 	}
+	defer dir.mu.Unlock()
 
-	buf := make([]byte, st.Size)
-	_, errno = f.Read(buf)
-	return buf, errno
+	if existing = m.entry(dir, name); existing != nil {
+		return existing, 0
+	}
+	if errno = m.access(dir, permWrite|permExec); errno != 0 {
+		return nil, errno
+	}
+	if !m.link(dir, name, n, isNew) {
+		return nil, sys.ENOENT
+	}
+	return nil, 0
 }
 
-// MemFS is a memory-only wazero filesystem, implementing just some basic functions.
-type MemFS struct {
-	fs *memfs.MemFS
+// addNewEntry is addEntry for a new inode n, which is counted against the
+// limits.
+func (m *MemFS) addNewEntry(dir *inode, name string, n *inode) (existing *inode, errno sys.Errno) {
+	if errno = m.quota.addInode(); errno != 0 {
+		return nil, errno
+	}
+	if existing, errno = m.addEntry(dir, name, n, true); existing != nil || errno != 0 {
+		m.quota.inodes.Add(-1)
+	}
+	return existing, errno
+}
 
-	sys.UnimplementedFS
+// lockEntry locks dir as lockDir does and returns it with its entry name,
+// checking the entry can be removed. dir.mu must be unlocked by the caller,
+// unless errno is returned.
+func (m *MemFS) lockEntry(dir *inode, name string) (_, n *inode, errno sys.Errno) {
+	if dir, errno = m.lockDir(dir); errno != 0 {
+		return nil, nil, errno
+	}
+	if errno = m.access(dir, permWrite|permExec); errno != 0 {
+		dir.mu.Unlock()
+		return nil, nil, errno
+	}
+	return dir, m.entry(dir, name), 0
 }
 
-// toOsOpenFlag is copied from wazero codebase
-func toOsOpenFlag(oflag sys.Oflag) (flag int) {
-	// First flags are exclusive
-	switch oflag & (sys.O_RDONLY | sys.O_RDWR | sys.O_WRONLY) {
-	case sys.O_RDONLY:
-		flag |= os.O_RDONLY
-	case sys.O_RDWR:
-		flag |= os.O_RDWR
-	case sys.O_WRONLY:
-		flag |= os.O_WRONLY
+// rmdir removes the entry name of dir, if it is an empty directory n. dir.mu
+// must be held, n.mu must not.
+func (m *MemFS) rmdir(dir *inode, name string, n *inode) sys.Errno {
+	n = m.mut(n.ino)
+	// hold n.mu so that no entry is added between the check and the removal
+	n.mu.Lock()
+	if len(n.entries) > 0 {
+		n.mu.Unlock()
+		return sys.ENOTEMPTY
 	}
+	delete(dir.entries, name)
+	dir.subdirs--
+	n.nlink--
+	n.mu.Unlock()
+
+	now := m.now()
+	dir.modified(now)
+	n.changed(now)
+	m.release(n.ino)
+	return 0
+}
 
-	// Run down the flags defined in the os package
-	if oflag&sys.O_APPEND != 0 {
-		flag |= os.O_APPEND
+// Mkdir creates a directory as defined in sys.FS.
+func (m *MemFS) Mkdir(path string, perm fs.FileMode) sys.Errno {
+	m.layerMu.RLock()
+	defer m.layerMu.RUnlock()
+
+	max := m.quota.limits.MaxDepth
+	if max > 0 {
+		m.renameMu.RLock()
+		defer m.renameMu.RUnlock()
+	}
+
+	dir, name, n, errno := m.lookup(path, false)
+	if errno != 0 {
+		return errno
 	}
-	if oflag&sys.O_CREAT != 0 {
-		flag |= os.O_CREATE
+	if n != nil {
+		return sys.EEXIST
 	}
-	if oflag&sys.O_EXCL != 0 {
-		flag |= os.O_EXCL
+	if max > 0 && m.depth(dir)+1 > max {
+		// it should be POSIX EDQUOT, which wazero doesn't have
+		return sys.EIO
 	}
-	if oflag&sys.O_SYNC != 0 {
-		flag |= os.O_SYNC
+	n = m.newInode(fs.ModeDir | perm&fs.ModePerm)
+	existing, errno := m.addNewEntry(dir, name, n)
+	if errno != 0 {
+		return errno
 	}
-	if oflag&sys.O_TRUNC != 0 {
-		flag |= os.O_TRUNC
+	if existing != nil {
+		return sys.EEXIST
 	}
-	return flag
+	m.notify(Mkdir, path, n.ino)
+	return 0
 }
 
-// OpenFile opens a file as defined in sys.File
-func (m *MemFS) OpenFile(path string, flag sys.Oflag, perm fs.FileMode) (sys.File, sys.Errno) {
-	f, err := m.fs.OpenFile(path, toOsOpenFlag(flag), perm)
-	if err != nil {
-		if errors.Is(err, memfs.ErrIsDirectory) {
-			if flag&sys.O_WRONLY == 1 || flag&sys.O_RDWR == 1 {
-				return nil, sys.EISDIR
-			}
-			// return directory as a different type
-			dir := &memoryFSDir{fs: m.fs, path: path}
-			return dir, 0
+// Unlink removes a file or a symlink as defined in sys.FS. The inode lives on
+// while other hard links or open files refer to it.
+func (m *MemFS) Unlink(path string) sys.Errno {
+	m.layerMu.RLock()
+	defer m.layerMu.RUnlock()
+
+	dir, name, _, errno := m.lookup(path, false)
+	if errno != 0 {
+		return errno
+	}
+	if name == "" {
+		// the root
+		return sys.EISDIR
+	}
+
+	dir, n, errno := m.lockEntry(dir, name)
+	if errno != 0 {
+		return errno
+	}
+	defer dir.mu.Unlock()
+
+	switch {
+	case n == nil:
+		return sys.ENOENT
+	case n.isDir():
+		return sys.EISDIR
+	}
+	m.unlink(dir, name)
+	m.notify(Unlink, path, n.ino)
+	return 0
+}
+
+// Rename renames a file or a directory as defined in sys.FS.
+func (m *MemFS) Rename(from, to string) sys.Errno {
+	m.layerMu.RLock()
+	defer m.layerMu.RUnlock()
+	m.renameMu.Lock()
+	defer m.renameMu.Unlock()
+
+	for {
+		if errno, retry := m.rename(from, to); !retry {
+			return errno
 		}
-		if errors.Is(err, os.ErrNotExist) {
-			return nil, sys.ENOENT
+	}
+}
+
+// rename does Rename; it returns retry if an entry changed between the lookups
+// and locking the directories. m.renameMu must be held.
+func (m *MemFS) rename(from, to string) (errno sys.Errno, retry bool) {
+	fromDir, fromName, fromNode, errno := m.lookup(from, false)
+	if errno != 0 {
+		return errno, false
+	}
+	if fromNode == nil {
+		return sys.ENOENT, false
+	}
+	toDir, toName, toNode, errno := m.lookup(to, false)
+	if errno != 0 {
+		return errno, false
+	}
+	if fromName == "" || toName == "" {
+		// renaming the root
+		return sys.EINVAL, false
+	}
+	if len(toName) > maxNameLen {
+		return sys.ENAMETOOLONG, false
+	}
+	var toIno wasys.Inode
+	if toNode != nil {
+		toIno = toNode.ino
+	}
+	if fromNode.ino == toIno {
+		// same name or hard links of the same file; POSIX says do nothing
+		return 0, false
+	}
+
+	// Directories cannot move while m.renameMu is held, so these checks stay
+	// valid as long as the entries are the same once the directories are
+	// locked.
+	if fromNode.isDir() && m.isAncestor(fromNode, toDir) {
+		// cannot move a directory into itself
+		return sys.EINVAL, false
+	}
+	if max := m.quota.limits.MaxDepth; max > 0 && fromNode.isDir() && !m.fits(fromNode, max-m.depth(toDir)-1) {
+		// it should be POSIX EDQUOT, which wazero doesn't have
+		return sys.EIO, false
+	}
+	if toNode != nil {
+		switch {
+		case fromNode.isDir() && !toNode.isDir():
+			return sys.ENOTDIR, false
+		case !fromNode.isDir() && toNode.isDir():
+			return sys.EISDIR, false
+		case toNode.isDir() && m.isAncestor(toNode, fromDir):
+			// contains from
+			return sys.ENOTEMPTY, false
 		}
-		if errors.Is(err, os.ErrExist) {
-			return nil, sys.EEXIST
+	} else if !fromNode.isDir() && dirPath(to) {
+		return sys.ENOTDIR, false
+	}
+
+	if fromDir, toDir = m.mut(fromDir.ino), m.mut(toDir.ino); fromDir == nil || toDir == nil {
+		// removed after the lookup
+		return sys.ENOENT, false
+	}
+	first, second := fromDir, toDir
+	if m.isAncestor(toDir, fromDir) {
+		first, second = toDir, fromDir
+	}
+	first.mu.Lock()
+	defer first.mu.Unlock()
+	if second != first {
+		second.mu.Lock()
+		defer second.mu.Unlock()
+	}
+
+	if fromDir.nlink == 0 || toDir.nlink == 0 {
+		// removed after the lookup
+		return sys.ENOENT, false
+	}
+	if fromDir.entries[fromName] != fromNode.ino || toDir.entries[toName] != toIno {
+		return 0, true
+	}
+	if errno = m.access(fromDir, permWrite|permExec); errno != 0 {
+		return errno, false
+	}
+	if errno = m.access(toDir, permWrite|permExec); errno != 0 {
+		return errno, false
+	}
+
+	if toNode != nil {
+		if toNode.isDir() {
+			if errno = m.rmdir(toDir, toName, toNode); errno != 0 {
+				return errno, false
+			}
+		} else {
+			m.unlink(toDir, toName)
 		}
-		return nil, sys.EINVAL // just general IO error, not that important
 	}
-	fl := &memoryFSFile{fl: f, path: path, fs: m.fs}
-	return fl, 0
+
+	// link first, so that the inode is never without links
+	m.link(toDir, toName, m.mut(fromNode.ino), false)
+	m.unlink(fromDir, fromName)
+	m.notifyRename(Rename, to, from, fromNode.ino)
+	return 0, false
 }
 
-func (m *MemFS) Mkdir(path string, perm fs.FileMode) sys.Errno {
-	err := m.fs.Mkdir(path, perm)
-	// note - this is not 100% correct, but good enough
-	// note - we canno "just" call stat here, as mkdir should be atomic; the file maybe doesn't exist anymore
-	// just return EEXIST I guess...
-	if err != nil {
-		if strings.Contains(err.Error(), "already exists") {
-			return sys.EEXIST
+// isAncestor returns true if dir is d or one of its ancestors. Only call
+// it with m.renameMu held and no inode locked.
+func (m *MemFS) isAncestor(dir, d *inode) bool {
+	for d.ino != dir.ino {
+		d.mu.RLock()
+		parent := d.parent
+		d.mu.RUnlock()
+		if parent == 0 {
+			return false
+		}
+		if d = m.get(parent); d == nil {
+			return false
 		}
+	}
+	return true
+}
+
+// Rmdir removes an empty directory as defined in sys.FS.
+func (m *MemFS) Rmdir(path string) sys.Errno {
+	m.layerMu.RLock()
+	defer m.layerMu.RUnlock()
+
+	dir, name, _, errno := m.lookup(path, false)
+	if errno != 0 {
+		return errno
+	}
+	if name == "" {
+		// removing the root
 		return sys.EINVAL
 	}
+
+	dir, n, errno := m.lockEntry(dir, name)
+	if errno != 0 {
+		return errno
+	}
+	defer dir.mu.Unlock()
+
+	switch {
+	case n == nil:
+		return sys.ENOENT
+	case !n.isDir():
+		return sys.ENOTDIR
+	}
+	if errno = m.rmdir(dir, name, n); errno != 0 {
+		return errno
+	}
+	m.notify(Rmdir, path, n.ino)
 	return 0
 }
 
-func (m *MemFS) Unlink(path string) sys.Errno {
-	err := m.fs.Remove(path)
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return sys.ENOENT
-		}
-		return sys.EINVAL
+// Symlink creates a symbolic link as defined in sys.FS. The target is stored
+// as is and resolved on each lookup, relative to the directory of the link;
+// it counts towards Limits.MaxBytes.
+func (m *MemFS) Symlink(oldPath, linkName string) sys.Errno {
+	m.layerMu.RLock()
+	defer m.layerMu.RUnlock()
+
+	if len(oldPath) > maxPathLen {
+		return sys.ENAMETOOLONG
 	}
+	dir, name, n, errno := m.lookup(linkName, false)
+	if errno != 0 {
+		return errno
+	}
+	if n != nil {
+		return sys.EEXIST
+	}
+	if dirPath(linkName) {
+		return sys.ENOENT
+	}
+	n = m.newInode(fs.ModeSymlink | 0o777)
+	n.target = oldPath
+	n.allocated = int64(len(oldPath))
+	if errno = m.quota.alloc(n.allocated); errno != 0 {
+		return errno
+	}
+	existing, errno := m.addNewEntry(dir, name, n)
+	if existing != nil || errno != 0 {
+		_ = m.quota.alloc(-n.allocated)
+	}
+	if errno != 0 {
+		return errno
+	}
+	if existing != nil {
+		return sys.EEXIST
+	}
+	m.notify(Create, linkName, n.ino)
 	return 0
 }
 
-func stat(mfs *memfs.MemFS, path string) (wasys.Stat_t, sys.Errno) {
-	fst, err := mfs.Stat(path)
-	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return wasys.Stat_t{}, sys.ENOENT
+// Readlink returns the target of a symbolic link as defined in sys.FS.
+func (m *MemFS) Readlink(path string) (string, sys.Errno) {
+	m.layerMu.RLock()
+	defer m.layerMu.RUnlock()
 
-		}
-		return wasys.Stat_t{}, sys.EIO // this should "never happen"
+	_, _, n, errno := m.lookup(path, false)
+	if errno != 0 {
+		return "", errno
+	}
+	if n == nil {
+		return "", sys.ENOENT
 	}
-	return wasys.NewStat_t(fst), 0
+	if !n.isSymlink() {
+		return "", sys.EINVAL
+	}
+	return n.target, 0
 }
 
-// Stat returns file stat as defined in sys.File
+// Link creates a hard link as defined in sys.FS. Both names share the same
+// inode, including its content.
+func (m *MemFS) Link(oldPath, newPath string) sys.Errno {
+	m.layerMu.RLock()
+	defer m.layerMu.RUnlock()
+
+	_, _, n, errno := m.lookup(oldPath, false)
+	if errno != 0 {
+		return errno
+	}
+	if n == nil {
+		return sys.ENOENT
+	}
+	if n.isDir() {
+		return sys.EPERM
+	}
+
+	dir, name, existing, errno := m.lookup(newPath, false)
+	if errno != 0 {
+		return errno
+	}
+	if existing != nil {
+		return sys.EEXIST
+	}
+	if dirPath(newPath) {
+		return sys.ENOENT
+	}
+	if n = m.mut(n.ino); n == nil {
+		// removed after the lookup
+		return sys.ENOENT
+	}
+	if existing, errno = m.addEntry(dir, name, n, false); errno != 0 {
+		return errno
+	}
+	if existing != nil {
+		return sys.EEXIST
+	}
+	m.notify(Create, newPath, n.ino)
+	return 0
+}
+
+// Stat returns file stat as defined in sys.FS, following symlinks.
 func (m *MemFS) Stat(path string) (wasys.Stat_t, sys.Errno) {
-	return stat(m.fs, path)
+	return m.stat(path, true)
+}
+
+// Lstat returns file stat as defined in sys.FS; a symlink is reported itself.
+func (m *MemFS) Lstat(path string) (wasys.Stat_t, sys.Errno) {
+	return m.stat(path, false)
+}
+
+func (m *MemFS) stat(path string, follow bool) (wasys.Stat_t, sys.Errno) {
+	m.layerMu.RLock()
+	defer m.layerMu.RUnlock()
+
+	_, _, n, errno := m.lookup(path, follow)
+	if errno != 0 {
+		return wasys.Stat_t{}, errno
+	}
+	if n == nil {
+		return wasys.Stat_t{}, sys.ENOENT
+	}
+	return m.statOf(n), 0
+}
+
+// Utimens sets the access and modification times of a file as defined in
+// sys.FS, following symlinks. Either can be sys.UTIME_OMIT to keep it.
+func (m *MemFS) Utimens(path string, atim, mtim int64) sys.Errno {
+	return m.utimens(path, true, atim, mtim)
+}
+
+// utimens does Utimens, or the same on a symlink itself unless follow is set.
+func (m *MemFS) utimens(path string, follow bool, atim, mtim int64) sys.Errno {
+	m.layerMu.RLock()
+	defer m.layerMu.RUnlock()
+
+	_, _, n, errno := m.lookup(path, follow)
+	if errno != 0 {
+		return errno
+	}
+	if n == nil {
+		return sys.ENOENT
+	}
+	if n = m.mut(n.ino); n == nil {
+		return sys.ENOENT
+	}
+	n.utimens(atim, mtim, m.now())
+	return 0
 }
@@ -0,0 +1,422 @@
+package memfs
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tetratelabs/wazero/experimental/sys"
+)
+
+// checkFS checks the invariants of the tree of m: every entry resolves to an
+// inode, directories have a single parent and the right count of
+// subdirectories, links are counted right, and so is the usage.
+func checkFS(t *testing.T, m *MemFS) {
+	t.Helper()
+	m.layerMu.RLock()
+	defer m.layerMu.RUnlock()
+
+	seen := map[*inode]uint64{}
+	checkDir(t, m, m.get(m.root), seen)
+	bytes := int64(0)
+	for n, links := range seen {
+		if !n.isDir() && links != n.nlink {
+			t.Errorf("inode %d has %d links, nlink is %d", n.ino, links, n.nlink)
+		}
+		bytes += n.allocated
+	}
+	// open files unlinked meanwhile are not in the tree
+	if u := m.Usage(); len(m.opens) == 0 && (u.Inodes != int64(len(seen))+1 || u.Bytes != bytes) {
+		t.Errorf("usage is %+v, counted %d inodes and %d bytes", u, len(seen)+1, bytes)
+	}
+}
+
+func checkDir(t *testing.T, m *MemFS, dir *inode, seen map[*inode]uint64) {
+	t.Helper()
+	subdirs := uint64(0)
+	for name, ino := range dir.entries {
+		n := m.get(ino)
+		if n == nil {
+			t.Fatalf("entry %s of inode %d is dangling", name, dir.ino)
+		}
+		seen[n]++
+		if !n.isDir() {
+			continue
+		}
+		subdirs++
+		if n.parent != dir.ino || n.nlink != 1 {
+			t.Fatalf("directory %s has parent %d and nlink %d, want %d and 1", name, n.parent, n.nlink, dir.ino)
+		}
+		checkDir(t, m, n, seen)
+	}
+	if subdirs != dir.subdirs {
+		t.Fatalf("inode %d has %d subdirectories, counted %d", dir.ino, dir.subdirs, subdirs)
+	}
+}
+
+func TestRenameRmdir(t *testing.T) {
+	m := New()
+	for _, p := range []string{"d", "d/c", "e"} {
+		if errno := m.Mkdir(p, 0o755); errno != 0 {
+			t.Fatalf("Mkdir(%s): %v", p, errno)
+		}
+	}
+	if errno := m.WriteFile("f", []byte("x"), 0o644); errno != 0 {
+		t.Fatal(errno)
+	}
+	for _, c := range []struct {
+		from, to string
+		want     sys.Errno
+	}{
+		{"d", "d/c/x", sys.EINVAL},
+		{"f", "d", sys.EISDIR},
+		{"d", "f", sys.ENOTDIR},
+		{"nope", "x", sys.ENOENT},
+		{"d", "e", 0},
+		{"e/c", "e", sys.ENOTEMPTY},
+		{"e", "/", sys.EINVAL},
+		{"f", "e/c/g", 0},
+	} {
+		if errno := m.Rename(c.from, c.to); errno != c.want {
+			t.Errorf("Rename(%s, %s) = %v, want %v", c.from, c.to, errno, c.want)
+		}
+	}
+	if got := readString(t, m, "e/c/g"); got != "x" {
+		t.Errorf("e/c/g is %q", got)
+	}
+	if errno := m.Rmdir("e"); errno != sys.ENOTEMPTY {
+		t.Errorf("Rmdir(e) = %v, want ENOTEMPTY", errno)
+	}
+	if errno := m.Rmdir("e/c/g"); errno != sys.ENOTDIR {
+		t.Errorf("Rmdir(e/c/g) = %v, want ENOTDIR", errno)
+	}
+	if errno := m.Unlink("e/c/g"); errno != 0 {
+		t.Fatal(errno)
+	}
+	if errno := m.Rmdir("e/c"); errno != 0 {
+		t.Errorf("Rmdir(e/c) = %v", errno)
+	}
+	checkFS(t, m)
+}
+
+func TestLinks(t *testing.T) {
+	m := New()
+	if errno := m.WriteFile("lib/libfoo.so.1", []byte("ELF"), 0o644); errno != 0 {
+		t.Fatal(errno)
+	}
+	if errno := m.Symlink("libfoo.so.1", "lib/libfoo.so"); errno != 0 {
+		t.Fatal(errno)
+	}
+	if content, errno := m.ReadFile("lib/libfoo.so"); errno != 0 || string(content) != "ELF" {
+		t.Errorf("ReadFile through symlink = %q, %v", content, errno)
+	}
+	if st, errno := m.Lstat("lib/libfoo.so"); errno != 0 || st.Mode.Type() != fs.ModeSymlink || st.Size != int64(len("libfoo.so.1")) {
+		t.Errorf("Lstat of the symlink has mode %v and size %d, %v", st.Mode, st.Size, errno)
+	}
+	if st, errno := m.Stat("lib/libfoo.so"); errno != 0 || !st.Mode.IsRegular() {
+		t.Errorf("Stat through the symlink has mode %v, %v", st.Mode, errno)
+	}
+	if errno := m.Symlink("loop", "loop"); errno != 0 {
+		t.Fatal(errno)
+	}
+	if _, errno := m.Stat("loop"); errno != sys.ELOOP {
+		t.Errorf("Stat(loop) = %v, want ELOOP", errno)
+	}
+
+	if errno := m.Link("lib/libfoo.so.1", "h"); errno != 0 {
+		t.Fatal(errno)
+	}
+	if st, _ := m.Stat("h"); st.Nlink != 2 {
+		t.Errorf("nlink of h is %d, want 2", st.Nlink)
+	}
+	if errno := m.Unlink("lib/libfoo.so.1"); errno != 0 {
+		t.Fatal(errno)
+	}
+	if content, errno := m.ReadFile("h"); errno != 0 || string(content) != "ELF" {
+		t.Errorf("ReadFile(h) = %q, %v", content, errno)
+	}
+	checkFS(t, m)
+}
+
+func TestPaths(t *testing.T) {
+	m := New()
+	for i, errno := range []sys.Errno{
+		m.WriteFile("f", []byte("x"), 0o644),
+		m.Mkdir("d/", 0o755),
+		m.Mkdir("e", 0o755),
+		m.Rename("e/", "g/"),
+		m.Symlink("g", "l"),
+	} {
+		if errno != 0 {
+			t.Fatalf("step %d: %v", i, errno)
+		}
+	}
+	for _, p := range []string{"d", "g", "g/", "l/", "/g/../d/.", "g/.."} {
+		if st, errno := m.Lstat(p); errno != 0 || !st.Mode.IsDir() {
+			t.Errorf("Lstat(%s) has mode %v, %v", p, st.Mode, errno)
+		}
+	}
+	for _, p := range []string{"f/", "f/.", "f/..", "f/../f"} {
+		if _, errno := m.Stat(p); errno != sys.ENOTDIR {
+			t.Errorf("Stat(%s) = %v, want ENOTDIR", p, errno)
+		}
+	}
+	if _, errno := m.OpenFile("f/", sys.O_RDONLY, 0); errno != sys.ENOTDIR {
+		t.Errorf("OpenFile(f/) = %v, want ENOTDIR", errno)
+	}
+
+	// only directories are created through a trailing slash
+	for _, c := range []struct {
+		op   string
+		want sys.Errno
+		fn   func() sys.Errno
+	}{
+		{"OpenFile(n/, O_CREAT)", sys.EISDIR, func() sys.Errno {
+			_, errno := m.OpenFile("n/", sys.O_RDWR|sys.O_CREAT, 0o644)
+			return errno
+		}},
+		{"Symlink(f, n/)", sys.ENOENT, func() sys.Errno { return m.Symlink("f", "n/") }},
+		{"Link(f, n/)", sys.ENOENT, func() sys.Errno { return m.Link("f", "n/") }},
+		{"Mkfifo(n/)", sys.ENOENT, func() sys.Errno { return m.Mkfifo("n/", 0o644) }},
+		{"Rename(f, n/)", sys.ENOTDIR, func() sys.Errno { return m.Rename("f", "n/") }},
+		{"Rename(f/, n)", sys.ENOTDIR, func() sys.Errno { return m.Rename("f/", "n") }},
+		{"Unlink(f/)", sys.ENOTDIR, func() sys.Errno { return m.Unlink("f/") }},
+		{"Mkdir(n/./)", sys.ENOENT, func() sys.Errno { return m.Mkdir("n/./", 0o755) }},
+	} {
+		if errno := c.fn(); errno != c.want {
+			t.Errorf("%s = %v, want %v", c.op, errno, c.want)
+		}
+	}
+	if ok, _ := m.Exists("n"); ok {
+		t.Error("n was created")
+	}
+	checkFS(t, m)
+}
+
+// TestConcurrent runs random operations on a small set of paths from many
+// goroutines; it is meant for the race detector.
+func TestConcurrent(t *testing.T) {
+	m := New()
+	runRandomOps(t, func() *MemFS { return m }, 8, 5000, nil)
+	checkFS(t, m)
+}
+
+// runRandomOps runs count random operations in each of goroutines, on the
+// MemFS returned by pick each time. clone is called for a few of them, if
+// set.
+func runRandomOps(t *testing.T, pick func() *MemFS, goroutines, count int, clone func(*MemFS)) {
+	t.Helper()
+	if testing.Short() {
+		count /= 10
+	}
+	names := []string{"a", "b", "a/b", "b/a", "a/b/c", "b/a/c", "c", "a/c", "b/c"}
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for i := 0; i < count; i++ {
+				m := pick()
+				p, q := names[r.Intn(len(names))], names[r.Intn(len(names))]
+				switch r.Intn(11) {
+				case 0:
+					m.Mkdir(p, 0o755)
+				case 1:
+					m.Rmdir(p)
+				case 2:
+					m.Rename(p, q)
+				case 3:
+					m.WriteFile(p, []byte(fmt.Sprint(i)), 0o644)
+				case 4:
+					m.ReadFile(p)
+				case 5:
+					m.Unlink(p)
+				case 6:
+					m.Symlink(q, p)
+				case 7:
+					m.Link(p, q)
+				case 8:
+					m.Stat(p)
+				case 9:
+					if f, errno := m.OpenFile(p, sys.O_RDONLY, 0); errno == 0 {
+						f.Readdir(-1)
+						f.Close()
+					}
+				case 10:
+					if clone != nil && r.Intn(20) == 0 {
+						clone(m)
+					}
+				}
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+}
+
+func TestOpenFlags(t *testing.T) {
+	m := New()
+	for i, errno := range []sys.Errno{
+		m.Mkdir("d", 0o755),
+		m.WriteFile("f", []byte("hello"), 0o644),
+		m.Symlink("f", "l"),
+	} {
+		if errno != 0 {
+			t.Fatalf("step %d: %v", i, errno)
+		}
+	}
+	for _, c := range []struct {
+		p    string
+		flag sys.Oflag
+		want sys.Errno
+	}{
+		{"d", sys.O_RDWR, sys.EISDIR},
+		{"d", sys.O_WRONLY, sys.EISDIR},
+		{"d", sys.O_RDONLY | sys.O_TRUNC, sys.EISDIR},
+		{"d", sys.O_RDONLY | sys.O_CREAT, sys.EISDIR},
+		{"d", sys.O_RDONLY | sys.O_DIRECTORY, 0},
+		{"f", sys.O_RDONLY | sys.O_DIRECTORY, sys.ENOTDIR},
+		{"f", sys.O_RDWR | sys.O_CREAT | sys.O_EXCL, sys.EEXIST},
+		{"l", sys.O_RDONLY | sys.O_NOFOLLOW, sys.ELOOP},
+		{"n", sys.O_RDONLY | sys.O_CREAT | sys.O_DIRECTORY, sys.EINVAL},
+	} {
+		f, errno := m.OpenFile(c.p, c.flag, 0o644)
+		if errno != c.want {
+			t.Errorf("OpenFile(%s, %v) = %v, want %v", c.p, c.flag, errno, c.want)
+		}
+		if f != nil {
+			f.Close()
+		}
+	}
+
+	f, errno := m.OpenFile("f", sys.O_RDWR|sys.O_APPEND, 0)
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	if !f.IsAppend() {
+		t.Error("the file opened with O_APPEND is not appending")
+	}
+	buf := make([]byte, 2)
+	f.Read(buf)
+	// writes go to the end, wherever the offset is; Pwrite too
+	f.Seek(0, io.SeekStart)
+	f.Write([]byte("!!"))
+	f.Pwrite([]byte("??"), 0)
+	f.SetAppend(false)
+	f.Pwrite([]byte("H"), 0)
+	off, _ := f.Seek(0, io.SeekCurrent)
+	f.Close()
+	if got := readString(t, m, "f"); string(buf) != "he" || got != "Hello!!??" || off != 7 {
+		t.Errorf("read %q, then the file is %q with offset %d", buf, got, off)
+	}
+
+	// even read-only
+	f, errno = m.OpenFile("f", sys.O_RDONLY|sys.O_TRUNC, 0)
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	f.Close()
+	if got := readString(t, m, "f"); got != "" {
+		t.Errorf("f is %q once truncated", got)
+	}
+}
+
+func TestFileKinds(t *testing.T) {
+	m := New()
+	if errno := m.WriteFile("d/f", []byte("x"), 0o644); errno != 0 {
+		t.Fatal(errno)
+	}
+	d, errno := m.OpenFile("d", sys.O_RDONLY, 0)
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	buf := make([]byte, 1)
+	for _, c := range []struct {
+		op   string
+		fn   func() sys.Errno
+		want sys.Errno
+	}{
+		{"Read", func() sys.Errno { _, errno := d.Read(buf); return errno }, sys.EISDIR},
+		{"Pread", func() sys.Errno { _, errno := d.Pread(buf, 0); return errno }, sys.EISDIR},
+		{"Write", func() sys.Errno { _, errno := d.Write(buf); return errno }, sys.EBADF},
+		{"Pwrite", func() sys.Errno { _, errno := d.Pwrite(buf, 0); return errno }, sys.EISDIR},
+		{"Truncate", func() sys.Errno { return d.Truncate(0) }, sys.EISDIR},
+	} {
+		if errno := c.fn(); errno != c.want {
+			t.Errorf("%s of a directory = %v, want %v", c.op, errno, c.want)
+		}
+	}
+	d.Close()
+	if _, errno := d.Read(buf); errno != sys.EBADF {
+		t.Errorf("Read of a closed directory = %v, want EBADF", errno)
+	}
+
+	f, errno := m.OpenFile("d/f", sys.O_RDONLY, 0)
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	defer f.Close()
+	if _, errno := f.Readdir(-1); errno != sys.EBADF {
+		t.Errorf("Readdir of a file = %v, want EBADF", errno)
+	}
+}
+
+func TestTimes(t *testing.T) {
+	now := int64(1000)
+	m := New(WithClock(func() time.Time { return time.Unix(0, now) }))
+	check := func(p string, atim, mtim, ctim int64) {
+		t.Helper()
+		if st, _ := m.Stat(p); st.Atim != atim || st.Mtim != mtim || st.Ctim != ctim {
+			t.Errorf("times of %s are %d, %d and %d; want %d, %d and %d", p, st.Atim, st.Mtim, st.Ctim, atim, mtim, ctim)
+		}
+	}
+	if errno := m.WriteFile("d/f", []byte("x"), 0o644); errno != 0 {
+		t.Fatal(errno)
+	}
+	check("d/f", 1000, 1000, 1000)
+
+	now = 2000
+	readString(t, m, "d/f")
+	check("d/f", 2000, 1000, 1000)
+	now = 3000
+	f, errno := m.OpenFile("d/f", sys.O_RDWR, 0)
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	defer f.Close()
+	if _, errno := f.Pwrite([]byte("y"), 0); errno != 0 {
+		t.Fatal(errno)
+	}
+	check("d/f", 2000, 3000, 3000)
+	now = 4000
+	if errno := m.Chmod("d/f", 0o600); errno != 0 {
+		t.Fatal(errno)
+	}
+	check("d/f", 2000, 3000, 4000)
+
+	now = 5000
+	if errno := m.Utimens("d/f", 10, sys.UTIME_OMIT); errno != 0 {
+		t.Fatal(errno)
+	}
+	check("d/f", 10, 3000, 5000)
+	if errno := f.Utimens(sys.UTIME_OMIT, 20); errno != 0 {
+		t.Fatal(errno)
+	}
+	check("d/f", 10, 20, 5000)
+
+	// entries change the directory
+	now = 6000
+	if errno := m.Mkdir("d/e", 0o755); errno != 0 {
+		t.Fatal(errno)
+	}
+	check("d", 1000, 6000, 6000)
+	now = 7000
+	if _, errno := m.readDir("d"); errno != 0 {
+		t.Fatal(errno)
+	}
+	check("d", 7000, 6000, 6000)
+}
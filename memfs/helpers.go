@@ -0,0 +1,186 @@
+package memfs
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/tetratelabs/wazero/experimental/sys"
+)
+
+// WriteFile writes content to the file path, as os.WriteFile: the file is
+// created with perm if needed, or truncated otherwise. Missing parent
+// directories are created with 0o777, so that test setup doesn't need
+// MkdirAll first.
+// Errors have the same semantics as wazero errors
+func (m *MemFS) WriteFile(path string, content []byte, perm fs.FileMode) sys.Errno {
+	f, err := m.OpenFile(path, sys.O_WRONLY|sys.O_CREAT|sys.O_TRUNC, perm)
+	if err == sys.ENOENT {
+		if err = m.MkdirAll(parentDir(path), 0o777); err != 0 {
+			return err
+		}
+		f, err = m.OpenFile(path, sys.O_WRONLY|sys.O_CREAT|sys.O_TRUNC, perm)
+	}
+	if err != 0 {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(content)
+	return err
+}
+
+// ReadFile returns the content of the file path, as os.ReadFile.
+// Errors have the same semantics as wazero errors
+func (m *MemFS) ReadFile(path string) ([]byte, sys.Errno) {
+	f, err := m.OpenFile(path, sys.O_RDONLY, 0)
+	if err != 0 {
+		return nil, err
+	}
+	defer f.Close()
+
+	st, errno := f.Stat()
+	if errno != 0 {
+		return nil, errno
+	}
+
+	// the size is just a hint, the file can change while being read
+	buf := make([]byte, 0, st.Size+1)
+	for {
+		if len(buf) == cap(buf) {
+			buf = append(buf, 0)[:len(buf)]
+		}
+		n, errno := f.Read(buf[len(buf):cap(buf)])
+		if errno != 0 {
+			return nil, errno
+		}
+		if n == 0 {
+			return buf, 0
+		}
+		buf = buf[:len(buf)+n]
+	}
+}
+
+// MkdirAll creates the directory path and its missing parents with perm, as
+// os.MkdirAll. It does nothing if path is already a directory, and fails with
+// ENOTDIR if it is something else.
+func (m *MemFS) MkdirAll(path string, perm fs.FileMode) sys.Errno {
+	st, errno := m.Stat(path)
+	switch {
+	case errno == 0 && st.Mode.IsDir():
+		return 0
+	case errno == 0:
+		return sys.ENOTDIR
+	case errno != sys.ENOENT:
+		return errno
+	}
+	if parent := parentDir(path); parent != path {
+		if errno = m.MkdirAll(parent, perm); errno != 0 {
+			return errno
+		}
+	}
+	if errno = m.Mkdir(path, perm); errno != 0 && errno != sys.EEXIST {
+		return errno
+	}
+	return 0
+}
+
+// parentDir returns the parent directory of p, which is p itself for the
+// root.
+func parentDir(p string) string {
+	return path.Dir(strings.TrimSuffix(p, "/"))
+}
+
+// RemoveAll removes path and everything it contains, as os.RemoveAll. It does
+// nothing if path doesn't exist, and never follows symlinks. The root itself
+// cannot be removed, so RemoveAll("/") only empties it.
+//
+// A file that cannot be removed doesn't stop the removal of the others; the
+// first error is returned.
+func (m *MemFS) RemoveAll(path string) sys.Errno {
+	st, errno := m.Lstat(path)
+	switch {
+	case errno == sys.ENOENT:
+		return 0
+	case errno != 0:
+		return errno
+	case !st.Mode.IsDir():
+		if errno = m.Unlink(path); errno == sys.ENOENT {
+			return 0
+		}
+		return errno
+	}
+
+	dirents, errno := m.readDir(path)
+	if errno == sys.ENOENT {
+		return 0
+	}
+	for _, dirent := range dirents {
+		if err := m.RemoveAll(joinPath(path, dirent.Name)); err != 0 && errno == 0 {
+			errno = err
+		}
+	}
+	if errno != 0 || st.Ino == m.root {
+		return errno
+	}
+	if errno = m.Rmdir(path); errno == sys.ENOENT {
+		return 0
+	}
+	return errno
+}
+
+// readDir returns the entries of the directory path, not following a
+// symlink.
+func (m *MemFS) readDir(path string) ([]sys.Dirent, sys.Errno) {
+	f, errno := m.OpenFile(path, sys.O_RDONLY|sys.O_DIRECTORY|sys.O_NOFOLLOW, 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	defer f.Close()
+	return f.Readdir(-1)
+}
+
+// joinPath joins the entry name to the directory dir, keeping dir as given
+// (relative or absolute).
+func joinPath(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	return path.Join(dir, name)
+}
+
+// Exists reports whether path exists, without following a symlink, so that
+// a dangling symlink exists too. A missing parent, or one that isn't
+// a directory, isn't an error.
+func (m *MemFS) Exists(path string) (bool, sys.Errno) {
+	switch _, errno := m.Lstat(path); errno {
+	case 0:
+		return true, 0
+	case sys.ENOENT, sys.ENOTDIR:
+		return false, 0
+	default:
+		return false, errno
+	}
+}
+
+// Glob returns the paths matching pattern, as path.Match, in lexical order.
+// A relative pattern matches relative paths, an absolute one absolute paths;
+// symlinks are followed. A malformed pattern fails with EINVAL; otherwise,
+// unreadable directories are skipped, as in fs.Glob.
+func (m *MemFS) Glob(pattern string) ([]string, sys.Errno) {
+	rel := strings.TrimLeft(pattern, "/")
+	if rel == "" {
+		rel = "."
+	}
+	matches, err := fs.Glob(m.FS(), rel)
+	if err != nil {
+		// the only error of fs.Glob
+		return nil, sys.EINVAL
+	}
+	if rel != pattern {
+		for i, match := range matches {
+			matches[i] = path.Join("/", match)
+		}
+	}
+	return matches, 0
+}
@@ -0,0 +1,377 @@
+package memfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	wasys "github.com/tetratelabs/wazero/sys"
+
+	"github.com/tetratelabs/wazero/experimental/sys"
+)
+
+// DumpTo writes the whole tree of m into the host directory hostDir, creating
+// it if needed, for inspecting a guest run with host tools. Directories,
+// regular files, hard links and symlinks are created with their modes and
+// modification times; other file types are skipped.
+//
+// Files already in hostDir are replaced. Host symlinks are never followed, so
+// nothing outside hostDir is written. Symlinks are written so that they point
+// to the same file as in m, and so never outside hostDir: each target is
+// resolved in m, following symlinks, as a path from the root, and written
+// relative to the link. A symlink that cannot be resolved (ELOOP) is
+// refused.
+//
+// A file that cannot be written doesn't stop the dump; the returned error
+// joins a *fs.PathError for each of them. If m is being changed meanwhile,
+// each file is written as it is at that time, as in WriteTar.
+func (m *MemFS) DumpTo(hostDir string) error {
+	if err := os.MkdirAll(hostDir, 0o777); err != nil {
+		return err
+	}
+	entries := m.list()[1:]
+	d := &dumper{
+		hostDir: hostDir,
+		entries: make(map[string]*inode, len(entries)),
+		links:   map[wasys.Inode]string{},
+		failed:  map[string]bool{},
+	}
+	for _, e := range entries {
+		d.entries[e.path] = e.n
+	}
+	var dirs []treeEntry
+	for _, e := range entries {
+		if d.failed[path.Dir(e.path)] {
+			// its directory couldn't be written
+			d.failed[e.path] = true
+			continue
+		}
+		if d.entry(e) && e.n.isDir() {
+			dirs = append(dirs, e)
+		}
+	}
+	// last first, so that a directory gets its mode and times once filled
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d.attrs(dirs[i])
+	}
+	return errors.Join(d.errs...)
+}
+
+// dumper holds the state of DumpTo.
+type dumper struct {
+	hostDir string
+	// entries holds the inode of each path being dumped.
+	entries map[string]*inode
+	// links holds the host path of each hard linked inode already written.
+	links map[wasys.Inode]string
+	// failed holds the paths of the directories that couldn't be written.
+	failed map[string]bool
+	errs   []error
+}
+
+func (d *dumper) fail(p string, err error) {
+	d.errs = append(d.errs, &fs.PathError{Op: "dump", Path: p, Err: err})
+}
+
+// host returns the host path of the path p of m.
+func (d *dumper) host(p string) string {
+	return filepath.Join(d.hostDir, filepath.FromSlash(p))
+}
+
+// entry dumps e, returning false if it couldn't be written. Directories are
+// only created; their mode and times are set by attrs.
+func (d *dumper) entry(e treeEntry) bool {
+	n, p, host := e.n, e.path, d.host(e.path)
+
+	existing, err := os.Lstat(host)
+	if err == nil && !(n.isDir() && existing.IsDir()) {
+		// replaced, so that a symlink in the way is never followed
+		if err = os.RemoveAll(host); err != nil {
+			d.fail(p, err)
+			d.failed[p] = true
+			return false
+		}
+	}
+
+	switch {
+	case n.isDir():
+		if err := os.Mkdir(host, 0o700); err != nil && !errors.Is(err, fs.ErrExist) {
+			d.fail(p, err)
+			d.failed[p] = true
+			return false
+		}
+		return true
+	case n.isSymlink():
+		target, ok := d.target(p, n.target)
+		if !ok {
+			d.fail(p, sys.ELOOP)
+			return false
+		}
+		if err := os.Symlink(filepath.FromSlash(target), host); err != nil {
+			d.fail(p, err)
+			return false
+		}
+		// the mode and times of a symlink cannot be set portably
+		return true
+	case n.isRegular() && e.st.Nlink > 1 && d.links[n.ino] != "":
+		if err := os.Link(d.links[n.ino], host); err != nil {
+			d.fail(p, err)
+			return false
+		}
+		return true
+	case n.isRegular():
+		if err := dumpFile(host, n); err != nil {
+			d.fail(p, err)
+			return false
+		}
+		if e.st.Nlink > 1 {
+			d.links[n.ino] = host
+		}
+		d.attrs(e)
+		return true
+	}
+	return false
+}
+
+// attrs sets the mode and times of the host file of e.
+func (d *dumper) attrs(e treeEntry) {
+	host := d.host(e.path)
+	if err := os.Chmod(host, e.st.Mode&chmodMask); err != nil {
+		d.fail(e.path, err)
+	}
+	if err := os.Chtimes(host, time.Unix(0, e.st.Atim), time.Unix(0, e.st.Mtim)); err != nil {
+		d.fail(e.path, err)
+	}
+}
+
+// dumpFile writes the content of n to the new host file host.
+func dumpFile(host string, n *inode) error {
+	f, err := os.OpenFile(host, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	n.mu.RLock()
+	err = n.writeTo(f)
+	n.mu.RUnlock()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// target returns the target of the symlink p as written by DumpTo: the path
+// it resolves to in m, relative to the directory of p. Only the last component
+// is left as is if it is a symlink, which is written so as well; the others
+// are always directories of the dump, never host symlinks, so the target
+// cannot lead out of hostDir. Components past a missing one are taken as they
+// are, with ".." stopping at the root as in m. It returns false if there are
+// too many symlinks to follow.
+func (d *dumper) target(p, target string) (string, bool) {
+	dir := parentPath(p)
+	resolved := dir
+	components := strings.Split(target, "/")
+	if path.IsAbs(target) {
+		resolved = ""
+	}
+	missing := false
+	for hops := 0; len(components) > 0; {
+		c := components[0]
+		components = components[1:]
+		switch c {
+		case "", ".":
+			continue
+		case "..":
+			resolved = parentPath(resolved)
+			continue
+		}
+
+		next := path.Join(resolved, c)
+		n := d.entries[next]
+		if missing || n == nil || !n.isSymlink() || len(components) == 0 {
+			missing = missing || n == nil
+			resolved = next
+			continue
+		}
+		if hops++; hops > maxSymlinkHops {
+			return "", false
+		}
+		components = append(strings.Split(n.target, "/"), components...)
+		if path.IsAbs(n.target) {
+			resolved = ""
+		}
+	}
+	return relPath(dir, resolved), true
+}
+
+// parentPath returns the directory of the path p of a dump, "" for the root.
+func parentPath(p string) string {
+	if dir := path.Dir(p); dir != "." {
+		return dir
+	}
+	return ""
+}
+
+// relPath returns the path to, relative to the directory from; both are paths
+// of a dump.
+func relPath(from, to string) string {
+	var f, t []string
+	if from != "" {
+		f = strings.Split(from, "/")
+	}
+	if to != "" {
+		t = strings.Split(to, "/")
+	}
+	common := 0
+	for common < len(f) && common < len(t) && f[common] == t[common] {
+		common++
+	}
+	rel := path.Join(strings.Repeat("../", len(f)-common), strings.Join(t[common:], "/"))
+	if rel == "" {
+		return "."
+	}
+	return rel
+}
+
+// LoadDir creates a new memory filesystem with opts, holding a copy of the
+// host directory hostDir. Directories, regular files and symlinks are copied
+// with their modes and modification times; hard links are copied as separate
+// files, and other file types are skipped.
+//
+// Symlinks are copied as symlinks and never followed. A symlink pointing
+// outside hostDir is refused; an absolute one pointing inside is made
+// absolute within the MemFS.
+//
+// A file that cannot be read doesn't stop the load; the returned MemFS holds
+// everything else, and the error joins a *fs.PathError for each such file.
+func LoadDir(hostDir string, opts ...Option) (*MemFS, error) {
+	root, err := filepath.Abs(hostDir)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "load", Path: hostDir, Err: sys.ENOTDIR}
+	}
+
+	l := &loader{m: New(opts...), root: root}
+	l.dir("/")
+	return l.m, errors.Join(l.errs...)
+}
+
+// loader holds the state of LoadDir.
+type loader struct {
+	m    *MemFS
+	root string
+	errs []error
+}
+
+func (l *loader) fail(p string, err error) {
+	l.errs = append(l.errs, &fs.PathError{Op: "load", Path: p, Err: err})
+}
+
+// dir loads the entries of the directory p, a path in the MemFS.
+func (l *loader) dir(p string) {
+	entries, err := os.ReadDir(filepath.Join(l.root, filepath.FromSlash(p)))
+	if err != nil {
+		l.fail(p, err)
+		return
+	}
+	for _, e := range entries {
+		l.entry(path.Join(p, e.Name()))
+	}
+}
+
+// entry loads p, a path in the MemFS.
+func (l *loader) entry(p string) {
+	host := filepath.Join(l.root, filepath.FromSlash(p))
+	info, err := os.Lstat(host)
+	if err != nil {
+		l.fail(p, err)
+		return
+	}
+
+	var errno sys.Errno
+	switch mode := info.Mode(); {
+	case mode.IsDir():
+		if errno = l.m.Mkdir(p, 0o700); errno != 0 {
+			break
+		}
+		l.dir(p)
+	case mode&fs.ModeSymlink != 0:
+		target, err := os.Readlink(host)
+		if err != nil {
+			l.fail(p, err)
+			return
+		}
+		if target, err = l.loadTarget(p, target); err != nil {
+			l.fail(p, err)
+			return
+		}
+		if errno = l.m.Symlink(target, p); errno != 0 {
+			l.fail(p, errno)
+		}
+		// the times of a symlink cannot be set portably
+		return
+	case mode.IsRegular():
+		if err := l.file(host, p); err != nil {
+			l.fail(p, err)
+			return
+		}
+	default:
+		return
+	}
+	if errno == 0 {
+		errno = l.m.Chmod(p, info.Mode()&chmodMask)
+	}
+	if errno == 0 {
+		errno = l.m.Utimens(p, sys.UTIME_OMIT, info.ModTime().UnixNano())
+	}
+	if errno != 0 {
+		l.fail(p, errno)
+	}
+}
+
+// file copies the content of the host file host to p.
+func (l *loader) file(host, p string) error {
+	r, err := os.Open(host)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	f, errno := l.m.OpenFile(p, sys.O_WRONLY|sys.O_CREAT|sys.O_EXCL, 0o600)
+	if errno != 0 {
+		return errno
+	}
+	defer f.Close()
+
+	_, err = io.Copy(fileWriter{f}, r)
+	return err
+}
+
+// loadTarget returns the target of the host symlink p as stored in the MemFS,
+// or an error if it points outside the root.
+func (l *loader) loadTarget(p, target string) (string, error) {
+	if filepath.IsAbs(target) {
+		rel, err := filepath.Rel(l.root, target)
+		if err != nil || !filepath.IsLocal(rel) && rel != "." {
+			return "", errors.New("symlink target " + target + " escapes the root")
+		}
+		return path.Join("/", filepath.ToSlash(rel)), nil
+	}
+	target = filepath.ToSlash(target)
+	resolved := path.Join(path.Dir(strings.TrimPrefix(p, "/")), target)
+	if resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return "", errors.New("symlink target " + target + " escapes the root")
+	}
+	return target, nil
+}
@@ -0,0 +1,133 @@
+package memfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tetratelabs/wazero/experimental/sys"
+)
+
+func TestDumpLoad(t *testing.T) {
+	m := New()
+	for i, errno := range []sys.Errno{
+		m.WriteFile("d/f", []byte("content"), 0o640),
+		m.Link("d/f", "h"),
+		m.Symlink("/d/f", "d/abs"),
+		m.Symlink("f", "d/rel"),
+		m.Mkdir("d/ro", 0o555),
+		m.Utimens("d", 12345, 67890),
+	} {
+		if errno != 0 {
+			t.Fatalf("step %d: %v", i, errno)
+		}
+	}
+	dir := t.TempDir()
+	// replaced, not followed
+	if err := os.Symlink(t.TempDir(), filepath.Join(dir, "h")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.DumpTo(dir); err != nil {
+		t.Fatal(err)
+	}
+	if m.top.below != nil {
+		t.Error("DumpTo froze the layer of m")
+	}
+
+	for _, p := range []string{"d/f", "h", "d/abs", "d/rel"} {
+		if content, err := os.ReadFile(filepath.Join(dir, p)); err != nil || string(content) != "content" {
+			t.Errorf("host %s is %q, %v", p, content, err)
+		}
+	}
+	if target, _ := os.Readlink(filepath.Join(dir, "d/abs")); target != "f" {
+		t.Errorf("host d/abs points to %q", target)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "d")); err != nil || info.ModTime().UnixNano() != 67890 {
+		t.Errorf("host d has mtime %v, %v", info.ModTime(), err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "d/ro")); err != nil || info.Mode().Perm() != 0o555 {
+		t.Errorf("host d/ro has mode %v, %v", info.Mode(), err)
+	}
+
+	loaded, err := LoadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readString(t, loaded, "d/abs"); got != "content" {
+		t.Errorf("loaded d/abs is %q", got)
+	}
+	if st, _ := loaded.Stat("d/f"); st.Mode != 0o640 {
+		t.Errorf("loaded d/f has mode %v", st.Mode)
+	}
+}
+
+func TestDumpSymlinks(t *testing.T) {
+	m := New()
+	for i, errno := range []sys.Errno{
+		m.WriteFile("secret", []byte("inside"), 0o644),
+		m.Symlink("/", "d"),
+		m.Symlink("d/../secret", "l"),
+		m.Mkdir("e", 0o755),
+		m.Symlink("../../../secret", "e/up"),
+		m.Symlink("../d/e/../secret", "e/via"),
+		m.Symlink("nope/../../secret", "e/missing"),
+		m.Symlink("loop/x", "loop"),
+	} {
+		if errno != 0 {
+			t.Fatalf("step %d: %v", i, errno)
+		}
+	}
+	parent := t.TempDir()
+	if err := os.WriteFile(filepath.Join(parent, "secret"), []byte("outside"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(parent, "dump")
+	if err := m.DumpTo(dir); err == nil {
+		t.Error("no error for the symlink loop")
+	}
+
+	for p, want := range map[string]string{
+		"d":         ".",
+		"l":         "secret",
+		"e/up":      "../secret",
+		"e/via":     "../secret",
+		"e/missing": "../secret",
+	} {
+		if target, err := os.Readlink(filepath.Join(dir, p)); err != nil || target != want {
+			t.Errorf("host %s points to %q, %v; want %q", p, target, err, want)
+		}
+	}
+	for _, p := range []string{"l", "e/up", "e/via", "d/secret"} {
+		if content, err := os.ReadFile(filepath.Join(dir, p)); err != nil || string(content) != "inside" {
+			t.Errorf("host %s is %q, %v", p, content, err)
+		}
+	}
+}
+
+func TestLoadDirEscape(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "f"), []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for name, target := range map[string]string{
+		"in":  filepath.Join(dir, "f"),
+		"out": filepath.Dir(dir),
+		"up":  "../x",
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m, err := LoadDir(dir)
+	if err == nil {
+		t.Error("no error for the symlinks escaping the directory")
+	}
+	if target, _ := m.Readlink("in"); target != "/f" {
+		t.Errorf("in points to %q", target)
+	}
+	for _, p := range []string{"out", "up"} {
+		if ok, _ := m.Exists(p); ok {
+			t.Errorf("%s was loaded", p)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package memfs
+
+import (
+	"io"
+	"io/fs"
+	"path"
+	"time"
+
+	wasys "github.com/tetratelabs/wazero/sys"
+
+	"github.com/tetratelabs/wazero/experimental/sys"
+)
+
+// FS returns a read-only io/fs view of m, so that it can be used with
+// fs.WalkDir, fs.Glob, testing/fstest or http.FS. The view is live: it shows
+// the tree as it is at the time of each call.
+//
+// It implements fs.ReadDirFS, fs.StatFS, fs.ReadFileFS, fs.SubFS and
+// ReadLinkFS. Symlinks are followed, except by ReadLink, Lstat and in the
+// types of directory entries. Opened files implement io.Seeker and
+// io.ReaderAt. Errors are *fs.PathError, wrapping fs.ErrNotExist and the like
+// where there is one, or the sys.Errno otherwise.
+func (m *MemFS) FS() fs.FS {
+	return &ioFS{m: m, dir: "."}
+}
+
+// ioFS is the view returned by FS, rooted at dir of m.
+type ioFS struct {
+	m   *MemFS
+	dir string
+}
+
+// path returns the path in m of name, or an error for op if it isn't valid.
+func (f *ioFS) path(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return path.Join(f.dir, name), nil
+}
+
+// pathError converts errno of op on name to the error io/fs users expect.
+func pathError(op, name string, errno sys.Errno) error {
+	var err error = errno
+	switch errno {
+	case sys.ENOENT:
+		err = fs.ErrNotExist
+	case sys.EEXIST:
+		err = fs.ErrExist
+	case sys.EACCES, sys.EPERM:
+		err = fs.ErrPermission
+	case sys.EBADF:
+		err = fs.ErrClosed
+	case sys.EINVAL:
+		err = fs.ErrInvalid
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+func (f *ioFS) Open(name string) (fs.File, error) {
+	p, err := f.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+	file, errno := f.m.OpenFile(p, sys.O_RDONLY, 0)
+	if errno != 0 {
+		return nil, pathError("open", name, errno)
+	}
+	if isDir, _ := file.IsDir(); isDir {
+		return &ioDir{fsys: f, name: name, f: file}, nil
+	}
+	return &ioFile{name: name, f: file}, nil
+}
+
+func (f *ioFS) Stat(name string) (fs.FileInfo, error) {
+	p, err := f.path("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	st, errno := f.m.Stat(p)
+	if errno != 0 {
+		return nil, pathError("stat", name, errno)
+	}
+	return &fileInfo{name: path.Base(name), st: st}, nil
+}
+
+func (f *ioFS) Lstat(name string) (fs.FileInfo, error) {
+	p, err := f.path("lstat", name)
+	if err != nil {
+		return nil, err
+	}
+	st, errno := f.m.Lstat(p)
+	if errno != 0 {
+		return nil, pathError("lstat", name, errno)
+	}
+	return &fileInfo{name: path.Base(name), st: st}, nil
+}
+
+func (f *ioFS) ReadLink(name string) (string, error) {
+	p, err := f.path("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	target, errno := f.m.Readlink(p)
+	if errno != 0 {
+		return "", pathError("readlink", name, errno)
+	}
+	return target, nil
+}
+
+func (f *ioFS) ReadFile(name string) ([]byte, error) {
+	p, err := f.path("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+	st, errno := f.m.Stat(p)
+	if errno == 0 && st.Mode.IsDir() {
+		errno = sys.EISDIR
+	}
+	var content []byte
+	if errno == 0 {
+		content, errno = f.m.ReadFile(p)
+	}
+	if errno != 0 {
+		return nil, pathError("readfile", name, errno)
+	}
+	return content, nil
+}
+
+func (f *ioFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	dir, ok := file.(*ioDir)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: sys.ENOTDIR}
+	}
+	return dir.ReadDir(-1)
+}
+
+func (f *ioFS) Sub(dir string) (fs.FS, error) {
+	p, err := f.path("sub", dir)
+	if err != nil {
+		return nil, err
+	}
+	return &ioFS{m: f.m, dir: p}, nil
+}
+
+// ioFile is a regular file opened by ioFS.
+type ioFile struct {
+	name string
+	f    sys.File
+}
+
+func (f *ioFile) Stat() (fs.FileInfo, error) {
+	st, errno := f.f.Stat()
+	if errno != 0 {
+		return nil, pathError("stat", f.name, errno)
+	}
+	return &fileInfo{name: path.Base(f.name), st: st}, nil
+}
+
+func (f *ioFile) Read(buf []byte) (int, error) {
+	n, errno := f.f.Read(buf)
+	if errno != 0 {
+		return n, pathError("read", f.name, errno)
+	}
+	if n == 0 && len(buf) > 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (f *ioFile) ReadAt(buf []byte, off int64) (int, error) {
+	total := 0
+	for total < len(buf) {
+		n, errno := f.f.Pread(buf[total:], off+int64(total))
+		if errno != 0 {
+			return total, pathError("read", f.name, errno)
+		}
+		if n == 0 {
+			return total, io.EOF
+		}
+		total += n
+	}
+	return total, nil
+}
+
+func (f *ioFile) Seek(offset int64, whence int) (int64, error) {
+	newOffset, errno := f.f.Seek(offset, whence)
+	if errno != 0 {
+		return newOffset, pathError("seek", f.name, errno)
+	}
+	return newOffset, nil
+}
+
+func (f *ioFile) Close() error {
+	if errno := f.f.Close(); errno != 0 {
+		return pathError("close", f.name, errno)
+	}
+	return nil
+}
+
+// ioDir is a directory opened by ioFS.
+type ioDir struct {
+	fsys *ioFS
+	name string
+	f    sys.File
+}
+
+func (d *ioDir) Stat() (fs.FileInfo, error) {
+	st, errno := d.f.Stat()
+	if errno != 0 {
+		return nil, pathError("stat", d.name, errno)
+	}
+	return &fileInfo{name: path.Base(d.name), st: st}, nil
+}
+
+func (d *ioDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: sys.EISDIR}
+}
+
+// ReadDir returns the next n entries as defined in fs.ReadDirFile.
+func (d *ioDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	dirents, errno := d.f.Readdir(n)
+	if errno != 0 {
+		return nil, pathError("readdir", d.name, errno)
+	}
+	if n > 0 && len(dirents) == 0 {
+		return nil, io.EOF
+	}
+	entries := make([]fs.DirEntry, len(dirents))
+	for i, dirent := range dirents {
+		entries[i] = &dirEntry{fsys: d.fsys, name: path.Join(d.name, dirent.Name), dirent: dirent}
+	}
+	return entries, nil
+}
+
+func (d *ioDir) Close() error {
+	if errno := d.f.Close(); errno != 0 {
+		return pathError("close", d.name, errno)
+	}
+	return nil
+}
+
+// dirEntry is an entry returned by ioDir.ReadDir; like os.DirEntry, its Info
+// is only read when asked for.
+type dirEntry struct {
+	fsys   *ioFS
+	name   string
+	dirent sys.Dirent
+}
+
+func (e *dirEntry) Name() string {
+	return e.dirent.Name
+}
+
+func (e *dirEntry) IsDir() bool {
+	return e.dirent.IsDir()
+}
+
+func (e *dirEntry) Type() fs.FileMode {
+	return e.dirent.Type
+}
+
+func (e *dirEntry) Info() (fs.FileInfo, error) {
+	return e.fsys.Lstat(e.name)
+}
+
+func (e *dirEntry) String() string {
+	return fs.FormatDirEntry(e)
+}
+
+// fileInfo is the fs.FileInfo of a file; Sys returns its *wasys.Stat_t.
+type fileInfo struct {
+	name string
+	st   wasys.Stat_t
+}
+
+func (i *fileInfo) Name() string {
+	return i.name
+}
+
+func (i *fileInfo) Size() int64 {
+	return i.st.Size
+}
+
+func (i *fileInfo) Mode() fs.FileMode {
+	return i.st.Mode
+}
+
+func (i *fileInfo) ModTime() time.Time {
+	return time.Unix(0, i.st.Mtim)
+}
+
+func (i *fileInfo) IsDir() bool {
+	return i.st.Mode.IsDir()
+}
+
+func (i *fileInfo) Sys() any {
+	return &i.st
+}
+
+func (i *fileInfo) String() string {
+	return fs.FormatFileInfo(i)
+}
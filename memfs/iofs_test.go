@@ -0,0 +1,41 @@
+package memfs
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/tetratelabs/wazero/experimental/sys"
+)
+
+func TestFS(t *testing.T) {
+	m := New()
+	for i, errno := range []sys.Errno{
+		m.WriteFile("a/b/c", []byte("hi"), 0o644),
+		m.WriteFile("a/d", []byte("x"), 0o644),
+		m.WriteFile("e", []byte("hello world"), 0o644),
+		m.Symlink("b/c", "a/l"),
+		m.Symlink("a", "ldir"),
+	} {
+		if errno != 0 {
+			t.Fatalf("step %d: %v", i, errno)
+		}
+	}
+	if err := fstest.TestFS(m.FS(), "a/b/c", "a/d", "e", "a/l"); err != nil {
+		t.Fatal(err)
+	}
+	sub, err := fs.Sub(m.FS(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(sub, "b/c", "d"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.FS().Open("nope"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open(nope) = %v, want fs.ErrNotExist", err)
+	}
+	if target, err := m.FS().(ReadLinkFS).ReadLink("ldir"); err != nil || target != "a" {
+		t.Errorf("ReadLink(ldir) = %q, %v", target, err)
+	}
+}
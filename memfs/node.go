@@ -0,0 +1,641 @@
+package memfs
+
+import (
+	"io"
+	"io/fs"
+	"maps"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	wasys "github.com/tetratelabs/wazero/sys"
+
+	"github.com/tetratelabs/wazero/experimental/sys"
+)
+
+// maxSymlinkHops is the number of symlinks followed during a lookup before
+// giving up with ELOOP; same as Linux.
+const maxSymlinkHops = 40
+
+// maxNameLen and maxPathLen are the longest name of an entry and target of a
+// symlink, NAME_MAX and PATH_MAX of Linux; longer ones fail with
+// ENAMETOOLONG.
+const (
+	maxNameLen = 255
+	maxPathLen = 4096
+)
+
+// inode is a node of the tree. Directory entries hold inode numbers, which
+// are resolved through the layers of the MemFS, so more entries can share
+// a single inode (hard links).
+//
+// Inode numbers are assigned sequentially from 1 (the root) and are never
+// reused within a MemFS, nor between the clones of a MemFS, which share the
+// counter: an inode number of two clones is either the same file, as it was
+// when they were cloned, or only used by one of them.
+//
+// # Locking
+//
+// Each inode has its own lock; there is no lock over the whole tree. When two
+// inodes are locked at once, a directory is always locked before its entries.
+// Rename, the only operation that locks two directories, is serialized by
+// MemFS.renameMu and locks an ancestor before its descendants.
+//
+// Inodes of frozen layers are never changed, so they are only read; an inode
+// is copied to the top layer by MemFS.mut before it is changed. Reading one
+// doesn't copy it: its access time goes to the top layer, see MemFS.accessed.
+type inode struct {
+	// ino, typ, target and device never change, so can be read without
+	// locking.
+	ino wasys.Inode
+	typ fs.FileMode // fs.ModeType bits
+	// target is set on symlinks only.
+	target string
+	// device is set on character devices only, see AddDevice.
+	device io.ReadWriter
+
+	// mu guards the fields below, except the timestamps.
+	mu sync.RWMutex
+
+	perm  fs.FileMode // permission bits, including setuid, setgid and sticky
+	nlink uint64
+
+	// parent, entries and subdirs are set on directories only; directories
+	// cannot be hard linked, so they have exactly one parent. Root has
+	// parent 0. parent is only changed by Rename, so reading it under
+	// MemFS.renameMu doesn't need mu.
+	parent  wasys.Inode
+	entries map[string]wasys.Inode
+	subdirs uint64
+
+	// fileSize, chunks and allocated are the content of a regular file,
+	// see chunk; chunks has no entries for holes. If sharedChunks is set,
+	// the map is shared with a frozen inode and must be copied before
+	// changing. gen never changes. allocated is also set on symlinks, to the
+	// length of target, as it counts towards Limits.MaxBytes.
+	fileSize     int64
+	chunks       map[int64]*chunk
+	sharedChunks bool
+	allocated    int64
+	gen          uint64
+
+	// atim, mtim and ctim are atomic, so that concurrent reads of a file
+	// only need mu for reading.
+	atim, mtim, ctim atomic.Int64
+}
+
+// layer maps inode numbers to inodes. Only the top layer of a MemFS changes;
+// the layers below it are frozen by Clone and can be shared by more MemFS.
+// Frozen layers are merged together by MemFS.freeze, so that there are few of
+// them.
+type layer struct {
+	// mu guards inodes and atimes of the top layer; frozen layers are read
+	// without it.
+	mu     sync.RWMutex
+	inodes map[wasys.Inode]*inode
+	// atimes holds the access times of inodes of the layers below, set by
+	// reads; they are not copied for that, see MemFS.accessed. Each is added
+	// once, then only stored into, so that reads don't lock the layer for
+	// writing each time. In a frozen layer, they can also be of its own
+	// inodes, which they override.
+	atimes map[wasys.Inode]*atomic.Int64
+	below  *layer
+}
+
+func newLayer(below *layer) *layer {
+	return &layer{
+		inodes: map[wasys.Inode]*inode{},
+		atimes: map[wasys.Inode]*atomic.Int64{},
+		below:  below,
+	}
+}
+
+func (n *inode) isDir() bool {
+	return n.typ == fs.ModeDir
+}
+
+func (n *inode) isSymlink() bool {
+	return n.typ == fs.ModeSymlink
+}
+
+func (n *inode) isRegular() bool {
+	return n.typ == 0
+}
+
+func (n *inode) isFifo() bool {
+	return n.typ == fs.ModeNamedPipe
+}
+
+func (n *inode) isDevice() bool {
+	return n.typ == fs.ModeDevice|fs.ModeCharDevice
+}
+
+// size returns the size of n; n.mu must be held.
+func (n *inode) size() int64 {
+	switch {
+	case n.isSymlink():
+		return int64(len(n.target))
+	case n.isRegular():
+		return n.fileSize
+	}
+	return 0
+}
+
+func (n *inode) stat(dev uint64) wasys.Stat_t {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	nlink := n.nlink
+	if n.isDir() {
+		// "." and the entry in parent, plus ".." of every subdirectory
+		nlink = 2 + n.subdirs
+	}
+	return wasys.Stat_t{
+		Dev:   dev,
+		Ino:   n.ino,
+		Mode:  n.typ | n.perm,
+		Nlink: nlink,
+		Size:  n.size(),
+		Atim:  n.atim.Load(),
+		Mtim:  n.mtim.Load(),
+		Ctim:  n.ctim.Load(),
+	}
+}
+
+// copy returns a copy of the frozen inode n, sharing its content.
+func (n *inode) copy() *inode {
+	c := &inode{
+		ino:          n.ino,
+		typ:          n.typ,
+		target:       n.target,
+		device:       n.device,
+		perm:         n.perm,
+		nlink:        n.nlink,
+		parent:       n.parent,
+		entries:      maps.Clone(n.entries),
+		subdirs:      n.subdirs,
+		fileSize:     n.fileSize,
+		chunks:       n.chunks,
+		sharedChunks: n.chunks != nil,
+		allocated:    n.allocated,
+		gen:          lastGen.Add(1),
+	}
+	c.atim.Store(n.atim.Load())
+	c.mtim.Store(n.mtim.Load())
+	c.ctim.Store(n.ctim.Load())
+	return c
+}
+
+// accessed marks the content of the inode ino as read. A frozen inode isn't
+// copied to the top layer for this, which would undo the sharing of Clone for
+// every file only read; its access time is held by the top layer instead.
+func (m *MemFS) accessed(ino wasys.Inode) {
+	now := m.now()
+	top := m.top
+	top.mu.RLock()
+	n, own := top.inodes[ino]
+	atim := top.atimes[ino]
+	top.mu.RUnlock()
+	if !own && atim == nil {
+		top.mu.Lock()
+		if n, own = top.inodes[ino]; !own {
+			if atim = top.atimes[ino]; atim == nil {
+				atim = new(atomic.Int64)
+				top.atimes[ino] = atim
+			}
+		}
+		top.mu.Unlock()
+	}
+	switch {
+	case n != nil:
+		n.atim.Store(now)
+	case !own:
+		atim.Store(now)
+	}
+}
+
+// atime returns the access time of n, which may be held by an upper layer;
+// m.layerMu must be held.
+func (m *MemFS) atime(n *inode) wasys.EpochNanos {
+	top := m.top
+	top.mu.RLock()
+	_, own := top.inodes[n.ino]
+	atim := top.atimes[n.ino]
+	top.mu.RUnlock()
+	for l := top.below; !own && atim == nil && l != nil; l = l.below {
+		_, own = l.inodes[n.ino]
+		atim = l.atimes[n.ino]
+	}
+	if atim != nil {
+		return atim.Load()
+	}
+	return n.atim.Load()
+}
+
+// statOf returns the stat of n, with its access time as atime; m.layerMu
+// must be held.
+func (m *MemFS) statOf(n *inode) wasys.Stat_t {
+	st := n.stat(m.dev)
+	st.Atim = m.atime(n)
+	return st
+}
+
+// modified marks the content of n as changed, which also changes its status.
+func (n *inode) modified(now wasys.EpochNanos) {
+	n.mtim.Store(now)
+	n.ctim.Store(now)
+}
+
+// changed marks the status (metadata) of n as changed.
+func (n *inode) changed(now wasys.EpochNanos) {
+	n.ctim.Store(now)
+}
+
+// utimens sets the access and modification times, keeping the ones set to
+// sys.UTIME_OMIT. The status change time is set to now.
+func (n *inode) utimens(atim, mtim, now wasys.EpochNanos) {
+	if atim != sys.UTIME_OMIT {
+		n.atim.Store(atim)
+	}
+	if mtim != sys.UTIME_OMIT {
+		n.mtim.Store(mtim)
+	}
+	n.ctim.Store(now)
+}
+
+// now returns the current time for timestamps.
+func (m *MemFS) now() wasys.EpochNanos {
+	return m.clock().UnixNano()
+}
+
+// get returns the inode ino for reading, or nil if there is none; m.layerMu
+// must be held.
+func (m *MemFS) get(ino wasys.Inode) *inode {
+	top := m.top
+	top.mu.RLock()
+	n, ok := top.inodes[ino]
+	top.mu.RUnlock()
+	if ok {
+		// nil if dropped
+		return n
+	}
+	for l := top.below; l != nil; l = l.below {
+		if n, ok := l.inodes[ino]; ok {
+			return n
+		}
+	}
+	return nil
+}
+
+// mut returns the inode ino for changing, copying it to the top layer if it
+// is frozen, or nil if there is none; m.layerMu must be held.
+func (m *MemFS) mut(ino wasys.Inode) *inode {
+	top := m.top
+	top.mu.RLock()
+	n, ok := top.inodes[ino]
+	top.mu.RUnlock()
+	if ok {
+		return n
+	}
+
+	top.mu.Lock()
+	defer top.mu.Unlock()
+	if n, ok := top.inodes[ino]; ok {
+		// copied or dropped meanwhile
+		return n
+	}
+	atim := top.atimes[ino]
+	for l := top.below; l != nil; l = l.below {
+		if atim == nil {
+			atim = l.atimes[ino]
+		}
+		if n, ok := l.inodes[ino]; ok {
+			if n == nil {
+				return nil
+			}
+			n = n.copy()
+			if atim != nil {
+				n.atim.Store(atim.Load())
+				delete(top.atimes, ino)
+			}
+			top.inodes[ino] = n
+			return n
+		}
+	}
+	return nil
+}
+
+// entry returns the inode of the entry name of dir, or nil; dir.mu must be
+// held.
+func (m *MemFS) entry(dir *inode, name string) *inode {
+	ino, ok := dir.entries[name]
+	if !ok {
+		return nil
+	}
+	return m.get(ino)
+}
+
+// dirents returns the entries of dir sorted by name; dir.mu must be held.
+func (m *MemFS) dirents(dir *inode) []sys.Dirent {
+	dirents := make([]sys.Dirent, 0, len(dir.entries))
+	for name, ino := range dir.entries {
+		dirents = append(dirents, sys.Dirent{Name: name, Ino: ino, Type: m.get(ino).typ})
+	}
+	sort.Slice(dirents, func(i, j int) bool { return dirents[i].Name < dirents[j].Name })
+	return dirents
+}
+
+// newInode creates an unlinked inode; it is added to the top layer when
+// linked.
+func (m *MemFS) newInode(mode fs.FileMode) *inode {
+	n := &inode{ino: m.lastIno.Add(1), typ: mode.Type(), perm: mode &^ fs.ModeType}
+	now := m.now()
+	n.atim.Store(now)
+	n.mtim.Store(now)
+	n.ctim.Store(now)
+	switch {
+	case n.isDir():
+		n.entries = map[string]wasys.Inode{}
+	case n.isRegular():
+		n.gen = lastGen.Add(1)
+	}
+	return n
+}
+
+// link adds an entry name pointing to n to directory dir; dir.mu must be
+// held, n.mu must not. Both must have been returned by mut (or newInode).
+//
+// An existing inode without links is being released, so it is not linked
+// again; link returns false then. It is checked under n.mu, so that release
+// never drops an inode linked meanwhile.
+func (m *MemFS) link(dir *inode, name string, n *inode, isNew bool) bool {
+	n.mu.Lock()
+	if !isNew && n.nlink == 0 {
+		n.mu.Unlock()
+		return false
+	}
+	dir.entries[name] = n.ino
+	if n.isDir() {
+		n.parent = dir.ino
+		dir.subdirs++
+	}
+	n.nlink++
+	n.mu.Unlock()
+
+	m.top.mu.Lock()
+	m.top.inodes[n.ino] = n
+	m.top.mu.Unlock()
+
+	now := m.now()
+	dir.modified(now)
+	n.changed(now)
+	return true
+}
+
+// unlink removes the entry name from directory dir; dir.mu must be held,
+// the mu of the entry must not.
+func (m *MemFS) unlink(dir *inode, name string) {
+	n := m.mut(dir.entries[name])
+	delete(dir.entries, name)
+	n.mu.Lock()
+	if n.isDir() {
+		dir.subdirs--
+	}
+	n.nlink--
+	n.mu.Unlock()
+
+	now := m.now()
+	dir.modified(now)
+	n.changed(now)
+	m.release(n.ino)
+}
+
+// opened marks the inode ino as open, so that it is kept while unlinked. It
+// returns false if the inode was dropped after the lookup.
+func (m *MemFS) opened(ino wasys.Inode) bool {
+	m.opensMu.Lock()
+	defer m.opensMu.Unlock()
+
+	if m.get(ino) == nil {
+		return false
+	}
+	m.opens[ino]++
+	return true
+}
+
+// closed undoes opened, dropping the inode if it was the last open of an
+// unlinked inode.
+func (m *MemFS) closed(ino wasys.Inode) {
+	m.opensMu.Lock()
+	m.opens[ino]--
+	last := m.opens[ino] == 0
+	if last {
+		delete(m.opens, ino)
+	}
+	m.opensMu.Unlock()
+	if last {
+		m.release(ino)
+	}
+}
+
+// release drops the inode ino if it has no links and isn't open. If a frozen
+// layer still has it, the top layer keeps a nil tombstone in its place.
+func (m *MemFS) release(ino wasys.Inode) {
+	n := m.get(ino)
+	if n == nil {
+		return
+	}
+	// checked before taking opensMu, as the caller may hold the lock of the
+	// parent directory; once without links, an inode never gets one again
+	n.mu.RLock()
+	gone := n.nlink == 0
+	n.mu.RUnlock()
+	if !gone {
+		return
+	}
+
+	// its content is accounted once unlocked, as locking it again under
+	// opensMu could deadlock; dropped, it cannot change anymore
+	var dropped *inode
+	own := false
+	defer func() {
+		if dropped != nil {
+			dropped.drop(&m.quota, own)
+		}
+	}()
+
+	m.opensMu.Lock()
+	defer m.opensMu.Unlock()
+	if m.opens[ino] > 0 {
+		return
+	}
+
+	top := m.top
+	top.mu.Lock()
+	defer top.mu.Unlock()
+	var frozen *inode
+	for l := top.below; l != nil; l = l.below {
+		if n, ok := l.inodes[ino]; ok {
+			frozen = n
+			break
+		}
+	}
+	dropped, own = top.inodes[ino]
+	if own && dropped == nil || !own && frozen == nil {
+		// released meanwhile
+		return
+	}
+	m.quota.inodes.Add(-1)
+	delete(top.inodes, ino)
+	delete(top.atimes, ino)
+	if frozen != nil {
+		top.inodes[ino] = nil
+	}
+	if !own {
+		dropped = frozen
+	}
+}
+
+// lookup resolves path to the directory containing its last component, the
+// name of that component and its inode (nil if it doesn't exist). Symlinks in
+// the middle of the path are always followed, the last one only if follow is
+// set. A path to the root returns an empty name.
+//
+// A path ending with a slash must resolve to a directory, so a symlink last
+// is followed and anything but a directory fails with ENOTDIR; the last
+// component can still be missing, but callers creating anything but a
+// directory must then fail, see dirPath.
+//
+// The inodes returned may be frozen, and no lock is held on return, so the
+// entry can change before the caller locks dir; callers changing dir must get
+// it with mut and look the entry up again under dir.mu.
+func (m *MemFS) lookup(path string, follow bool) (dir *inode, name string, n *inode, errno sys.Errno) {
+	root := m.get(m.root)
+	dir, n = root, root
+	components := strings.Split(path, "/")
+	hops := 0
+	mustDir := false
+	for len(components) > 0 {
+		c := components[0]
+		components = components[1:]
+
+		switch c {
+		case "":
+			continue
+		case ".", "..":
+			if !n.isDir() {
+				return nil, "", nil, sys.ENOTDIR
+			}
+			if c == "." {
+				continue
+			}
+			n.mu.RLock()
+			parent := n.parent
+			n.mu.RUnlock()
+			if parent != 0 {
+				if n = m.get(parent); n == nil {
+					// removed meanwhile
+					return nil, "", nil, sys.ENOENT
+				}
+			}
+			dir, name = n, ""
+			continue
+		}
+
+		if !n.isDir() {
+			return nil, "", nil, sys.ENOTDIR
+		}
+		dir, name = n, c
+		dir.mu.RLock()
+		errno = m.access(dir, permExec)
+		n = m.entry(dir, c)
+		dir.mu.RUnlock()
+		if errno != 0 {
+			return nil, "", nil, errno
+		}
+
+		// only empty components left, so a trailing slash at most
+		last, trailing := true, len(components) > 0
+		for _, c := range components {
+			if c != "" {
+				last = false
+				break
+			}
+		}
+		mustDir = last && trailing
+		if n == nil {
+			if last {
+				return dir, name, nil, 0
+			}
+			return nil, "", nil, sys.ENOENT
+		}
+
+		if n.isSymlink() && (follow || !last || mustDir) {
+			hops++
+			if hops > maxSymlinkHops {
+				return nil, "", nil, sys.ELOOP
+			}
+			components = append(strings.Split(n.target, "/"), components...)
+			if strings.HasPrefix(n.target, "/") {
+				n = root
+			} else {
+				n = dir
+			}
+		}
+	}
+	if mustDir && !n.isDir() {
+		return nil, "", nil, sys.ENOTDIR
+	}
+	return dir, name, n, 0
+}
+
+// dirPath reports whether path ends with a slash, so that it can only name a
+// directory; see lookup.
+func dirPath(path string) bool {
+	return strings.HasSuffix(path, "/")
+}
+
+// treeEntry is a file or directory listed by MemFS.list.
+type treeEntry struct {
+	// path is relative to the root, which is listed with an empty path.
+	path  string
+	depth int
+	n     *inode
+	// st is the stat of n at the time of the listing.
+	st wasys.Stat_t
+}
+
+// list returns the whole tree of m: the root, then each directory followed by
+// its entries, depth-first and sorted by name. m.layerMu is only held while
+// listing, so that the inodes can then be read for as long as needed without
+// holding up Clone, each under its own lock. Listing doesn't change the
+// access times.
+func (m *MemFS) list() []treeEntry {
+	m.layerMu.RLock()
+	defer m.layerMu.RUnlock()
+
+	root := m.get(m.root)
+	return m.listDir([]treeEntry{{n: root, st: m.statOf(root)}}, root, "", 1)
+}
+
+// listDir appends the entries of dir, whose path is p, to l.
+func (m *MemFS) listDir(l []treeEntry, dir *inode, p string, depth int) []treeEntry {
+	dir.mu.RLock()
+	dirents := m.dirents(dir)
+	dir.mu.RUnlock()
+
+	for _, dirent := range dirents {
+		n := m.get(dirent.Ino)
+		if n == nil {
+			continue
+		}
+		e := treeEntry{path: path.Join(p, dirent.Name), depth: depth, n: n, st: m.statOf(n)}
+		l = append(l, e)
+		if n.isDir() {
+			l = m.listDir(l, n, e.path, depth+1)
+		}
+	}
+	return l
+}
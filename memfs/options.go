@@ -0,0 +1,79 @@
+package memfs
+
+import "time"
+
+// Option configures a MemFS created by New.
+type Option func(*MemFS)
+
+// WithClock makes all timestamps of the filesystem come from clock instead of
+// time.Now, so that runs against a fresh MemFS are reproducible.
+func WithClock(clock func() time.Time) Option {
+	return func(m *MemFS) {
+		m.clock = clock
+	}
+}
+
+// WithFixedTime sets all timestamps of the filesystem to t, like
+// SOURCE_DATE_EPOCH does for reproducible builds. Utimens still works.
+func WithFixedTime(t time.Time) Option {
+	return WithClock(func() time.Time { return t })
+}
+
+// WithPermissions enforces the owner permission bits, as if the guest was
+// a regular (non-root) user owning all files: opening a 0444 file for write,
+// creating files in a 0555 directory or walking through a 0666 directory
+// fail with EACCES. Without this option, the mode bits are only reported.
+func WithPermissions() Option {
+	return func(m *MemFS) {
+		m.checkPerm = true
+	}
+}
+
+// WithDev sets the device ID (Stat_t.Dev) reported for all files of the
+// filesystem. By default, it is 1 for every MemFS created by New, so that
+// fresh filesystems are alike; give each its own ID if their files must not
+// compare equal by (Dev, Ino). Clone gives each clone an ID of its own,
+// above the default.
+func WithDev(dev uint64) Option {
+	return func(m *MemFS) {
+		m.dev = dev
+	}
+}
+
+// WithLimits bounds the total size, file size, number of inodes and directory
+// depth of the filesystem; see Limits.
+func WithLimits(limits Limits) Option {
+	return func(m *MemFS) {
+		m.quota.limits = limits
+	}
+}
+
+// WithSpill keeps the content of regular files larger than threshold bytes in
+// an unlinked temp file created in the host directory dir (os.TempDir if
+// empty), instead of memory, for guests writing files too large for the RAM
+// of the host. Directories, metadata and smaller files stay in memory, and
+// the filesystem behaves the same; an I/O error of the temp file fails with
+// EIO.
+//
+// The temp file is created when first needed, shared with clones, and only
+// grows: the space of removed or truncated contents is reused. It is closed
+// once neither the MemFS nor any of its clones is reachable anymore.
+func WithSpill(threshold int64, dir string) Option {
+	return func(m *MemFS) {
+		m.spill = &spill{threshold: threshold, dir: dir}
+	}
+}
+
+// WithBlobStore stores the content of regular files in s once they are
+// written, when the file is closed, so that identical contents share memory
+// with all other files and MemFSs using s, such as many copies of the same
+// tree loaded for different tenants. It changes nothing else: Limits and
+// Usage still count each file in full. See BlobStore.Stats for the memory
+// saved.
+//
+// Contents spilled to the temp file of WithSpill are not deduplicated.
+func WithBlobStore(s *BlobStore) Option {
+	return func(m *MemFS) {
+		m.blobs = s
+	}
+}
@@ -0,0 +1,84 @@
+package memfs
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	wasys "github.com/tetratelabs/wazero/sys"
+
+	"github.com/tetratelabs/wazero/experimental/sys"
+)
+
+func TestDev(t *testing.T) {
+	m := New(WithDev(42))
+	if errno := m.WriteFile("d/f", []byte("x"), 0o644); errno != 0 {
+		t.Fatal(errno)
+	}
+	for _, p := range []string{"d", "d/f"} {
+		st, errno := m.Stat(p)
+		if errno != 0 || st.Dev != 42 {
+			t.Errorf("Stat(%s) has dev %d, %v", p, st.Dev, errno)
+		}
+		f, errno := m.OpenFile(p, sys.O_RDONLY, 0)
+		if errno != 0 {
+			t.Fatal(errno)
+		}
+		dev, _ := f.Dev()
+		ino, _ := f.Ino()
+		f.Close()
+		if dev != 42 || ino != st.Ino || ino == 0 {
+			t.Errorf("file %s has dev %d and ino %d, Stat has %d and %d", p, dev, ino, st.Dev, st.Ino)
+		}
+	}
+	d, _ := m.Stat("d")
+	f, _ := m.Stat("d/f")
+	if d.Ino == f.Ino {
+		t.Error("d and d/f have the same inode number")
+	}
+
+	// kept by renames and clones
+	if errno := m.Rename("d/f", "g"); errno != 0 {
+		t.Fatal(errno)
+	}
+	if st, _ := m.Stat("g"); st.Ino != f.Ino {
+		t.Errorf("inode number %d once renamed, want %d", st.Ino, f.Ino)
+	}
+	c := m.Clone()
+	if st, _ := c.Stat("g"); st.Dev == 42 || st.Ino != f.Ino {
+		t.Errorf("g of the clone has dev %d and ino %d", st.Dev, st.Ino)
+	}
+	if New().dev != New().dev {
+		t.Error("fresh filesystems have different devs")
+	}
+}
+
+func TestFixedTime(t *testing.T) {
+	run := func() map[string]wasys.Stat_t {
+		m := New(WithFixedTime(time.Unix(1700000000, 0)))
+		for i, errno := range []sys.Errno{
+			m.WriteFile("d/f", []byte("content"), 0o644),
+			m.Symlink("f", "d/l"),
+			m.Link("d/f", "h"),
+			m.Chmod("d", 0o700),
+		} {
+			if errno != 0 {
+				t.Fatalf("step %d: %v", i, errno)
+			}
+		}
+		readString(t, m, "d/l")
+		stats := map[string]wasys.Stat_t{}
+		m.Walk("/", func(p string, st wasys.Stat_t, errno sys.Errno) sys.Errno {
+			stats[p] = st
+			return errno
+		})
+		return stats
+	}
+	a, b := run(), run()
+	if len(a) != 5 || !reflect.DeepEqual(a, b) {
+		t.Errorf("two runs gave\n%v\nand\n%v", a, b)
+	}
+	if st := a["/d/f"]; st.Atim != 1700000000e9 || st.Mtim != st.Atim || st.Ctim != st.Atim {
+		t.Errorf("times of d/f are %d, %d and %d", st.Atim, st.Mtim, st.Ctim)
+	}
+}
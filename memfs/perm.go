@@ -0,0 +1,70 @@
+package memfs
+
+import (
+	"io/fs"
+
+	"github.com/tetratelabs/wazero/experimental/sys"
+)
+
+// Owner permission bits checked by access; there are no users in a MemFS, so
+// the guest is always the owner.
+const (
+	permRead  fs.FileMode = 0o400
+	permWrite fs.FileMode = 0o200
+	permExec  fs.FileMode = 0o100
+)
+
+// chmodMask are the mode bits Chmod can change.
+const chmodMask = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+
+// access returns EACCES if permission checks are enabled and n lacks any of
+// the owner bits in perm; n.mu must be held.
+func (m *MemFS) access(n *inode, perm fs.FileMode) sys.Errno {
+	if m.checkPerm && n.perm&perm != perm {
+		return sys.EACCES
+	}
+	return 0
+}
+
+// accessOflag checks the permission needed to open n with flag.
+func (m *MemFS) accessOflag(n *inode, flag sys.Oflag) sys.Errno {
+	var perm fs.FileMode
+	switch flag & (sys.O_RDONLY | sys.O_RDWR | sys.O_WRONLY) {
+	case sys.O_RDONLY:
+		perm = permRead
+	case sys.O_WRONLY:
+		perm = permWrite
+	case sys.O_RDWR:
+		perm = permRead | permWrite
+	}
+	if flag&sys.O_TRUNC != 0 {
+		perm |= permWrite
+	}
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return m.access(n, perm)
+}
+
+// Chmod changes the mode bits of a file as defined in sys.FS, following
+// symlinks.
+func (m *MemFS) Chmod(path string, perm fs.FileMode) sys.Errno {
+	m.layerMu.RLock()
+	defer m.layerMu.RUnlock()
+
+	_, _, n, errno := m.lookup(path, true)
+	if errno != 0 {
+		return errno
+	}
+	if n == nil {
+		return sys.ENOENT
+	}
+	if n = m.mut(n.ino); n == nil {
+		return sys.ENOENT
+	}
+	n.mu.Lock()
+	n.perm = perm & chmodMask
+	n.mu.Unlock()
+	n.changed(m.now())
+	m.notify(Chmod, path, n.ino)
+	return 0
+}
@@ -0,0 +1,87 @@
+package memfs
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/tetratelabs/wazero/experimental/sys"
+)
+
+func TestPermissions(t *testing.T) {
+	m := New(WithPermissions())
+	for i, errno := range []sys.Errno{
+		m.WriteFile("ro", []byte("x"), 0o444),
+		m.WriteFile("wo", []byte("x"), 0o200),
+		m.WriteFile("rodir/f", []byte("x"), 0o644),
+		m.Chmod("rodir", 0o555),
+		m.WriteFile("noexec/f", []byte("x"), 0o644),
+		m.Chmod("noexec", 0o666),
+	} {
+		if errno != 0 {
+			t.Fatalf("step %d: %v", i, errno)
+		}
+	}
+
+	for _, c := range []struct {
+		p    string
+		flag sys.Oflag
+		want sys.Errno
+	}{
+		{"ro", sys.O_RDONLY, 0},
+		{"ro", sys.O_WRONLY, sys.EACCES},
+		{"ro", sys.O_RDWR, sys.EACCES},
+		{"ro", sys.O_RDONLY | sys.O_TRUNC, sys.EACCES},
+		{"wo", sys.O_RDONLY, sys.EACCES},
+		{"wo", sys.O_WRONLY, 0},
+		{"rodir/f", sys.O_RDWR, 0},
+		{"rodir/g", sys.O_RDWR | sys.O_CREAT, sys.EACCES},
+		{"noexec/f", sys.O_RDONLY, sys.EACCES},
+	} {
+		f, errno := m.OpenFile(c.p, c.flag, 0o644)
+		if errno != c.want {
+			t.Errorf("OpenFile(%s, %v) = %v, want %v", c.p, c.flag, errno, c.want)
+		}
+		if f != nil {
+			f.Close()
+		}
+	}
+	for _, c := range []struct {
+		op string
+		fn func() sys.Errno
+	}{
+		{"Mkdir(rodir/d)", func() sys.Errno { return m.Mkdir("rodir/d", 0o755) }},
+		{"Unlink(rodir/f)", func() sys.Errno { return m.Unlink("rodir/f") }},
+		{"Rename(rodir/f, g)", func() sys.Errno { return m.Rename("rodir/f", "g") }},
+		{"Symlink(f, rodir/l)", func() sys.Errno { return m.Symlink("f", "rodir/l") }},
+	} {
+		if errno := c.fn(); errno != sys.EACCES {
+			t.Errorf("%s = %v, want EACCES", c.op, errno)
+		}
+	}
+
+	// the mode bits can still be changed back
+	if errno := m.Chmod("ro", 0o644); errno != 0 {
+		t.Fatal(errno)
+	}
+	if errno := m.WriteFile("ro", []byte("y"), 0o644); errno != 0 {
+		t.Errorf("WriteFile once writable = %v", errno)
+	}
+	if st, _ := m.Stat("ro"); st.Mode != 0o644 {
+		t.Errorf("ro has mode %v", st.Mode)
+	}
+	if st, _ := m.Stat("rodir"); st.Mode != fs.ModeDir|0o555 {
+		t.Errorf("rodir has mode %v", st.Mode)
+	}
+
+	// only reported without WithPermissions
+	m = New()
+	if errno := m.WriteFile("ro", []byte("x"), 0o444); errno != 0 {
+		t.Fatal(errno)
+	}
+	f, errno := m.OpenFile("ro", sys.O_RDWR, 0)
+	if errno != 0 {
+		t.Errorf("OpenFile(ro, O_RDWR) without WithPermissions = %v", errno)
+	} else {
+		f.Close()
+	}
+}
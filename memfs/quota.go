@@ -0,0 +1,128 @@
+package memfs
+
+import (
+	"sync/atomic"
+
+	"github.com/tetratelabs/wazero/experimental/sys"
+)
+
+// Limits bounds the resources of a MemFS, so that an untrusted guest cannot
+// use up the memory of the host. Zero means no limit.
+//
+// wazero has no ENOSPC, EFBIG or EDQUOT, so going over any limit fails with
+// EIO, as other Errnos wazero lacks; Usage tells which limit was hit.
+type Limits struct {
+	// MaxBytes is the content allocated to all regular files, including
+	// unlinked files still open and contents spilled by WithSpill, and the
+	// targets of symlinks; holes of sparse files don't count. Write, Pwrite
+	// and Symlink fail past it (POSIX ENOSPC).
+	MaxBytes int64
+
+	// MaxFileSize is the size of a single regular file, holes included.
+	// Write, Pwrite and Truncate fail past it (POSIX EFBIG).
+	MaxFileSize int64
+
+	// MaxInodes is the number of files, directories and symlinks, including
+	// the root. Creating more with OpenFile, Mkdir or Symlink fails (POSIX
+	// EDQUOT).
+	MaxInodes int64
+
+	// MaxDepth is how deep directories can be nested below the root; a
+	// directory directly in the root has depth 1. Mkdir fails deeper, and so
+	// does Rename if the directory moved or any below it would be deeper
+	// (POSIX EDQUOT).
+	MaxDepth int
+}
+
+// Usage is the current resource usage of a MemFS, as counted by Limits.
+type Usage struct {
+	// Bytes is the content allocated to all regular files and symlinks.
+	Bytes int64
+	// Inodes is the number of files, directories and symlinks.
+	Inodes int64
+}
+
+// quota counts the usage of a MemFS against its limits.
+type quota struct {
+	limits Limits
+	bytes  atomic.Int64
+	inodes atomic.Int64
+}
+
+// fileSize checks a regular file can have size.
+func (q *quota) fileSize(size int64) sys.Errno {
+	if q.limits.MaxFileSize > 0 && size > q.limits.MaxFileSize {
+		// it should be POSIX EFBIG but wazero maps that to EIO
+		return sys.EIO
+	}
+	return 0
+}
+
+// alloc accounts delta bytes allocated to regular files, or freed if
+// negative.
+func (q *quota) alloc(delta int64) sys.Errno {
+	if q.bytes.Add(delta) > q.limits.MaxBytes && q.limits.MaxBytes > 0 && delta > 0 {
+		q.bytes.Add(-delta)
+		// it should be POSIX ENOSPC, which wazero doesn't have
+		return sys.EIO
+	}
+	return 0
+}
+
+// addInode accounts a new inode.
+func (q *quota) addInode() sys.Errno {
+	if q.inodes.Add(1) > q.limits.MaxInodes && q.limits.MaxInodes > 0 {
+		q.inodes.Add(-1)
+		// it should be POSIX EDQUOT, which wazero doesn't have
+		return sys.EIO
+	}
+	return 0
+}
+
+// Usage returns the current resource usage of m.
+func (m *MemFS) Usage() Usage {
+	return Usage{Bytes: m.quota.bytes.Load(), Inodes: m.quota.inodes.Load()}
+}
+
+// depth returns how deep dir is below the root.
+func (m *MemFS) depth(dir *inode) int {
+	depth := 0
+	for {
+		dir.mu.RLock()
+		parent := dir.parent
+		dir.mu.RUnlock()
+		if parent == 0 {
+			return depth
+		}
+		if dir = m.get(parent); dir == nil {
+			return depth
+		}
+		depth++
+	}
+}
+
+// fits reports whether dir has at most levels of directories below it, so
+// that it can be moved to a depth of MaxDepth minus levels. m.renameMu must
+// be held, so that no directory is added meanwhile.
+func (m *MemFS) fits(dir *inode, levels int) bool {
+	if levels < 0 {
+		return false
+	}
+	dir.mu.RLock()
+	var subdirs []*inode
+	if dir.subdirs > 0 {
+		for _, ino := range dir.entries {
+			if n := m.get(ino); n != nil && n.isDir() {
+				subdirs = append(subdirs, n)
+			}
+		}
+	}
+	dir.mu.RUnlock()
+
+	for _, n := range subdirs {
+		if !m.fits(n, levels-1) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,129 @@
+package memfs
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tetratelabs/wazero/experimental/sys"
+)
+
+func TestLimits(t *testing.T) {
+	m := New(WithLimits(Limits{MaxBytes: 100, MaxFileSize: 60, MaxInodes: 5}))
+	for _, c := range []struct {
+		p    string
+		size int
+		want sys.Errno
+	}{
+		{"a", 61, sys.EIO},
+		{"a", 60, 0},
+		{"b", 41, sys.EIO},
+	} {
+		if errno := m.WriteFile(c.p, make([]byte, c.size), 0o644); errno != c.want {
+			t.Errorf("WriteFile(%s, %d bytes) = %v, want %v", c.p, c.size, errno, c.want)
+		}
+	}
+	// b was created before its content failed
+	if u := m.Usage(); u.Bytes != 60 || u.Inodes != 3 {
+		t.Errorf("usage is %+v", u)
+	}
+	if errno := m.Mkdir("d", 0o755); errno != 0 {
+		t.Fatal(errno)
+	}
+	if errno := m.Mkdir("e", 0o755); errno != 0 {
+		t.Fatal(errno)
+	}
+	if errno := m.Mkdir("f", 0o755); errno != sys.EIO {
+		t.Errorf("Mkdir past MaxInodes = %v", errno)
+	}
+
+	// an unlinked file still open is counted until closed, also by clones
+	f, errno := m.OpenFile("a", sys.O_RDWR, 0)
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	m.Unlink("a")
+	if u := m.Usage(); u.Bytes != 60 || u.Inodes != 5 {
+		t.Errorf("usage with a unlinked is %+v", u)
+	}
+	c := m.Clone()
+	f.Close()
+	for _, x := range []*MemFS{m, c} {
+		if u := x.Usage(); u.Bytes != 0 || u.Inodes != 4 {
+			t.Errorf("usage of dev %d once a is closed is %+v", x.dev, u)
+		}
+		checkFS(t, x)
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	m := New(WithLimits(Limits{MaxDepth: 2}))
+	for _, p := range []string{"a", "a/b", "c"} {
+		if errno := m.Mkdir(p, 0o755); errno != 0 {
+			t.Fatalf("Mkdir(%s): %v", p, errno)
+		}
+	}
+	if errno := m.Mkdir("a/b/c", 0o755); errno != sys.EIO {
+		t.Errorf("Mkdir(a/b/c) = %v, want EIO", errno)
+	}
+	if errno := m.Rename("a", "c/a"); errno != sys.EIO {
+		t.Errorf("Rename(a, c/a) = %v, want EIO", errno)
+	}
+	if errno := m.Rename("a/b", "c/b"); errno != 0 {
+		t.Errorf("Rename(a/b, c/b) = %v", errno)
+	}
+
+	// nesting by moving the tree into a new directory each time
+	for i := 0; i < 5; i++ {
+		m.Mkdir("n", 0o755)
+		m.Rename("c", "n/c")
+		m.Rename("n", "c")
+	}
+	m.WalkDir("/", func(p string, d sys.Dirent, _ sys.Errno) sys.Errno {
+		if depth := strings.Count(p, "/"); p != "/" && d.IsDir() && depth > 2 {
+			t.Errorf("directory %s has depth %d", p, depth)
+		}
+		return 0
+	})
+	checkFS(t, m)
+}
+
+func TestSymlinkLimits(t *testing.T) {
+	m := New(WithLimits(Limits{MaxBytes: 1024}))
+	long := strings.Repeat("x", 1000)
+	if errno := m.Symlink(long, "s"); errno != 0 {
+		t.Fatal(errno)
+	}
+	if u := m.Usage(); u.Bytes != 1000 {
+		t.Errorf("usage with the symlink is %+v", u)
+	}
+	if errno := m.Symlink(long, "t"); errno != sys.EIO {
+		t.Errorf("Symlink past MaxBytes = %v, want EIO", errno)
+	}
+	if errno := m.Symlink(long, "s"); errno != sys.EEXIST {
+		t.Errorf("Symlink on an existing name = %v, want EEXIST", errno)
+	}
+	if errno := m.Unlink("s"); errno != 0 {
+		t.Fatal(errno)
+	}
+	if u := m.Usage(); u.Bytes != 0 {
+		t.Errorf("usage once the symlink is removed is %+v", u)
+	}
+
+	if errno := New().Symlink(strings.Repeat("x", 4097), "s"); errno != sys.ENAMETOOLONG {
+		t.Errorf("Symlink of a target past PATH_MAX = %v, want ENAMETOOLONG", errno)
+	}
+	name := strings.Repeat("n", 256)
+	if errno := m.Symlink("x", name); errno != sys.ENAMETOOLONG {
+		t.Errorf("Symlink of a name past NAME_MAX = %v, want ENAMETOOLONG", errno)
+	}
+	if errno := m.Mkdir(name, 0o755); errno != sys.ENAMETOOLONG {
+		t.Errorf("Mkdir of a name past NAME_MAX = %v, want ENAMETOOLONG", errno)
+	}
+	if errno := m.Mkdir("d", 0o755); errno != 0 {
+		t.Fatal(errno)
+	}
+	if errno := m.Rename("d", name); errno != sys.ENAMETOOLONG {
+		t.Errorf("Rename to a name past NAME_MAX = %v, want ENAMETOOLONG", errno)
+	}
+	checkFS(t, m)
+}
@@ -0,0 +1,99 @@
+package memfs
+
+import (
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/tetratelabs/wazero/experimental/sys"
+)
+
+// spill is the host temp file holding the chunks of large files, see
+// WithSpill. It is shared by all clones of the MemFS it was set on, and is
+// cut into blocks of chunkSize, each holding a single chunk.
+type spill struct {
+	threshold int64
+	dir       string
+
+	// mu guards the fields below; f never changes once set, so blocks read
+	// and write it without locking.
+	mu sync.Mutex
+	f  *os.File
+	// name is set if f couldn't be unlinked while open, as on Windows, so
+	// that it is removed once closed.
+	name string
+	end  int64
+	free []int64
+}
+
+// block is a block of a spill file. It is put back once no chunk uses it
+// anymore, so blocks shared with frozen inodes are freed by the garbage
+// collector; the exclusive ones are released as soon as they are dropped.
+type block struct {
+	s   *spill
+	off int64
+}
+
+// alloc returns a free block of s, creating the file on first use.
+func (s *spill) alloc() (*block, sys.Errno) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.f == nil {
+		f, err := os.CreateTemp(s.dir, "memfs-")
+		if err != nil {
+			return nil, sys.EIO
+		}
+		if err = os.Remove(f.Name()); err != nil {
+			s.name = f.Name()
+		}
+		s.f = f
+		runtime.SetFinalizer(s, (*spill).close)
+	}
+
+	b := &block{s: s, off: s.end}
+	if last := len(s.free) - 1; last >= 0 {
+		b.off = s.free[last]
+		s.free = s.free[:last]
+	} else {
+		s.end += chunkSize
+	}
+	runtime.SetFinalizer(b, (*block).put)
+	return b, 0
+}
+
+// close closes the file of s, once no MemFS uses it anymore.
+func (s *spill) close() {
+	s.f.Close()
+	if s.name != "" {
+		os.Remove(s.name)
+	}
+}
+
+// release frees b, which no chunk uses anymore.
+func (b *block) release() {
+	runtime.SetFinalizer(b, nil)
+	b.put()
+}
+
+func (b *block) put() {
+	b.s.mu.Lock()
+	b.s.free = append(b.s.free, b.off)
+	b.s.mu.Unlock()
+}
+
+// readAt reads buf from b at off, within the block.
+func (b *block) readAt(buf []byte, off int) sys.Errno {
+	if _, err := b.s.f.ReadAt(buf, b.off+int64(off)); err != nil {
+		return sys.EIO
+	}
+	return 0
+}
+
+// writeAt writes buf to b at off, within the block.
+func (b *block) writeAt(buf []byte, off int) sys.Errno {
+	if _, err := b.s.f.WriteAt(buf, b.off+int64(off)); err != nil {
+		return sys.EIO
+	}
+	return 0
+}
@@ -0,0 +1,102 @@
+package memfs
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/tetratelabs/wazero/experimental/sys"
+)
+
+// TestSpill checks random writes, truncations, removals and clones against a
+// reference in memory, with everything, some or nothing spilled.
+func TestSpill(t *testing.T) {
+	dir := t.TempDir()
+	rounds := 3000
+	if testing.Short() {
+		rounds /= 10
+	}
+	for _, threshold := range []int64{0, 6000, 1 << 30} {
+		m := New(WithSpill(threshold, dir))
+		rng := rand.New(rand.NewSource(threshold))
+		want := map[string][]byte{}
+		var clones []*MemFS
+		var cloneWants []map[string][]byte
+		for i := 0; i < rounds; i++ {
+			name := fmt.Sprintf("f%d", rng.Intn(4))
+			f, errno := m.OpenFile(name, sys.O_RDWR|sys.O_CREAT, 0o644)
+			if errno != 0 {
+				t.Fatal(errno)
+			}
+			content, exists := want[name], true
+			switch rng.Intn(6) {
+			case 0:
+				size := rng.Intn(30000)
+				if errno := f.Truncate(int64(size)); errno != 0 {
+					t.Fatal(errno)
+				}
+				if size < len(content) {
+					content = content[:size]
+				} else {
+					content = append(content, make([]byte, size-len(content))...)
+				}
+			case 1:
+				if errno := m.Unlink(name); errno != 0 {
+					t.Fatal(errno)
+				}
+				exists = false
+			case 2:
+				clones = append(clones, m.Clone())
+				cloneWant := map[string][]byte{}
+				for name, content := range want {
+					cloneWant[name] = bytes.Clone(content)
+				}
+				cloneWants = append(cloneWants, cloneWant)
+			default:
+				off := rng.Intn(30000)
+				buf := make([]byte, rng.Intn(9000))
+				rng.Read(buf)
+				if _, errno := f.Pwrite(buf, int64(off)); errno != 0 {
+					t.Fatal(errno)
+				}
+				if end := off + len(buf); end > len(content) {
+					content = append(content, make([]byte, end-len(content))...)
+				}
+				copy(content[off:], buf)
+			}
+			f.Close()
+			if exists {
+				want[name] = content
+			} else {
+				delete(want, name)
+			}
+			if i%200 == 0 {
+				// frees the blocks shared with clones
+				runtime.GC()
+			}
+		}
+
+		check := func(m *MemFS, want map[string][]byte) {
+			for name, content := range want {
+				if got, errno := m.ReadFile(name); errno != 0 || !bytes.Equal(got, content) {
+					t.Fatalf("threshold %d: %s of dev %d is %d bytes, %v; want %d bytes", threshold, name, m.dev, len(got), errno, len(content))
+				}
+			}
+		}
+		check(m, want)
+		for i, c := range clones {
+			check(c, cloneWants[i])
+		}
+		if threshold == 1<<30 && m.spill.f != nil {
+			t.Error("the temp file was created below the threshold")
+		}
+	}
+
+	// unlinked once created
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("%d files left in the temp dir", len(entries))
+	}
+}
@@ -0,0 +1,214 @@
+package memfs
+
+import (
+	"archive/tar"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	wasys "github.com/tetratelabs/wazero/sys"
+
+	"github.com/tetratelabs/wazero/experimental/sys"
+)
+
+// WriteTar writes the whole tree of m to w as a tar archive, which NewFromTar
+// reads back. Directories, regular files, symlinks and hard links are stored
+// with their modes and modification times, in PAX format to keep nanoseconds;
+// other file types are skipped. The root is stored first, as "./".
+//
+// Entries are ordered depth-first by name, and no other metadata (owners,
+// access and status change times) is stored, so the same tree always gives
+// the same bytes, even once its files were read. If m is being
+// changed meanwhile, each file is stored as it is when written, so the
+// archive may hold some of the changes only.
+func (m *MemFS) WriteTar(w io.Writer) error {
+	tw := tar.NewWriter(w)
+	// the path of each hard linked inode already written
+	links := map[wasys.Inode]string{}
+	for _, e := range m.list() {
+		n := e.n
+		hdr := &tar.Header{Name: e.path, Format: tar.FormatPAX}
+		if e.path == "" {
+			hdr.Name = "."
+		}
+		hardLinked := !n.isDir() && e.st.Nlink > 1
+		switch {
+		case hardLinked && links[n.ino] != "":
+			hdr.Typeflag = tar.TypeLink
+			hdr.Linkname = links[n.ino]
+		case n.isDir():
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name += "/"
+		case n.isSymlink():
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = n.target
+		case n.isRegular():
+			hdr.Typeflag = tar.TypeReg
+		default:
+			continue
+		}
+		if hardLinked && links[n.ino] == "" {
+			links[n.ino] = e.path
+		}
+		if err := writeTarEntry(tw, hdr, n); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// writeTarEntry writes hdr of n to tw, completed with the mode, modification
+// time and content of n; the size is read with the content, so that they
+// match.
+func writeTarEntry(tw *tar.Writer, hdr *tar.Header, n *inode) error {
+	if hdr.Typeflag == tar.TypeLink {
+		return tw.WriteHeader(hdr)
+	}
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	hdr.Mode = tarMode(n.perm)
+	hdr.ModTime = time.Unix(0, n.mtim.Load())
+	if hdr.Typeflag == tar.TypeReg {
+		hdr.Size = n.fileSize
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if hdr.Typeflag == tar.TypeReg {
+		return n.writeTo(tw)
+	}
+	return nil
+}
+
+// tarMode returns the tar mode bits of perm.
+func tarMode(perm fs.FileMode) int64 {
+	mode := int64(perm & fs.ModePerm)
+	if perm&fs.ModeSetuid != 0 {
+		mode |= 0o4000
+	}
+	if perm&fs.ModeSetgid != 0 {
+		mode |= 0o2000
+	}
+	if perm&fs.ModeSticky != 0 {
+		mode |= 0o1000
+	}
+	return mode
+}
+
+// NewFromTar creates a new memory filesystem with opts, holding the files of
+// the tar archive r, as written by WriteTar or any tar tool. Parent
+// directories missing from the archive are created. Entries other than
+// directories, regular files, symlinks and hard links are skipped.
+func NewFromTar(r io.Reader, opts ...Option) (*MemFS, error) {
+	m := New(opts...)
+	tr := tar.NewReader(r)
+
+	// directories get their mode and times once filled
+	var dirs []*tar.Header
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name := path.Clean("/" + hdr.Name)
+		if name == "/" {
+			if hdr.Typeflag == tar.TypeDir {
+				dirs = append(dirs, hdr)
+			}
+			continue
+		}
+		if hdr.Typeflag != tar.TypeDir {
+			if errno := m.MkdirAll(path.Dir(name), 0o777); errno != 0 {
+				return nil, &fs.PathError{Op: "untar", Path: hdr.Name, Err: errno}
+			}
+		}
+
+		var errno sys.Errno
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			errno = m.MkdirAll(name, 0o700)
+			dirs = append(dirs, hdr)
+		case tar.TypeReg, tar.TypeRegA:
+			if err := m.untarFile(tr, hdr, name); err != nil {
+				return nil, err
+			}
+		case tar.TypeSymlink:
+			if errno = m.Symlink(hdr.Linkname, name); errno == 0 {
+				errno = m.untarAttrs(hdr, name)
+			}
+		case tar.TypeLink:
+			errno = m.Link(path.Clean("/"+hdr.Linkname), name)
+		}
+		if errno != 0 {
+			return nil, &fs.PathError{Op: "untar", Path: hdr.Name, Err: errno}
+		}
+	}
+
+	// deepest first, so that setting the times of a directory isn't undone by
+	// its subdirectories
+	sort.SliceStable(dirs, func(i, j int) bool {
+		return strings.Count(path.Clean(dirs[i].Name), "/") > strings.Count(path.Clean(dirs[j].Name), "/")
+	})
+	for _, hdr := range dirs {
+		if errno := m.untarAttrs(hdr, path.Clean("/"+hdr.Name)); errno != 0 {
+			return nil, &fs.PathError{Op: "untar", Path: hdr.Name, Err: errno}
+		}
+	}
+	return m, nil
+}
+
+// untarFile creates the regular file name with the content of the current
+// entry hdr of tr.
+func (m *MemFS) untarFile(tr *tar.Reader, hdr *tar.Header, name string) error {
+	f, errno := m.OpenFile(name, sys.O_WRONLY|sys.O_CREAT|sys.O_TRUNC, 0o600)
+	if errno != 0 {
+		return &fs.PathError{Op: "untar", Path: hdr.Name, Err: errno}
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(fileWriter{f}, tr); err != nil {
+		return &fs.PathError{Op: "untar", Path: hdr.Name, Err: err}
+	}
+	if errno = m.untarAttrs(hdr, name); errno != 0 {
+		return &fs.PathError{Op: "untar", Path: hdr.Name, Err: errno}
+	}
+	return nil
+}
+
+// untarAttrs sets the mode and times of name from hdr; a symlink only gets
+// the times, as its mode cannot change. The access time is only set if hdr
+// has one, which WriteTar doesn't store.
+func (m *MemFS) untarAttrs(hdr *tar.Header, name string) sys.Errno {
+	symlink := hdr.Typeflag == tar.TypeSymlink
+	if !symlink {
+		if errno := m.Chmod(name, hdr.FileInfo().Mode()&chmodMask); errno != 0 {
+			return errno
+		}
+	}
+	atim := int64(sys.UTIME_OMIT)
+	if !hdr.AccessTime.IsZero() {
+		atim = hdr.AccessTime.UnixNano()
+	}
+	return m.utimens(name, !symlink, atim, hdr.ModTime.UnixNano())
+}
+
+// fileWriter is an io.Writer writing to a sys.File.
+type fileWriter struct {
+	f sys.File
+}
+
+func (w fileWriter) Write(buf []byte) (int, error) {
+	n, errno := w.f.Write(buf)
+	if errno != 0 {
+		return n, errno
+	}
+	return n, nil
+}
@@ -0,0 +1,90 @@
+package memfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/fs"
+	"testing"
+
+	"github.com/tetratelabs/wazero/experimental/sys"
+)
+
+func newTarTree(t *testing.T) *MemFS {
+	t.Helper()
+	m := New(WithPermissions())
+	for i, errno := range []sys.Errno{
+		m.Mkdir("d", 0o755),
+		m.Mkdir("d/e", 0o555),
+		m.WriteFile("d/f", []byte("content"), 0o644),
+		m.Chmod("d/f", fs.ModeSetuid|0o751),
+		m.Link("d/f", "h"),
+		m.Symlink("d/f", "s"),
+		m.Utimens("d", 12345, 67890),
+		m.Chmod("/", 0o755),
+		m.Utimens("/", 12345, 54321),
+	} {
+		if errno != 0 {
+			t.Fatalf("step %d: %v", i, errno)
+		}
+	}
+	return m
+}
+
+func writeTar(t *testing.T, m *MemFS) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := m.WriteTar(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestTar(t *testing.T) {
+	m := newTarTree(t)
+	archive := writeTar(t, m)
+	if m.top.below != nil {
+		t.Error("WriteTar froze the layer of m")
+	}
+
+	if hdr, err := tar.NewReader(bytes.NewReader(archive)).Next(); err != nil || hdr.Name != "./" {
+		t.Errorf("the first entry is %v, %v; want the root", hdr, err)
+	}
+
+	m2, err := NewFromTar(bytes.NewReader(archive), WithPermissions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(writeTar(t, m2), archive) {
+		t.Error("the archive of the restored tree differs")
+	}
+	if st, _ := m2.Stat("h"); st.Nlink != 2 || st.Mode != fs.ModeSetuid|0o751 {
+		t.Errorf("h has nlink %d and mode %v", st.Nlink, st.Mode)
+	}
+	if st, _ := m2.Stat("d"); st.Mtim != 67890 {
+		t.Errorf("d has mtime %d", st.Mtim)
+	}
+	if st, _ := m2.Stat("/"); st.Mode != fs.ModeDir|0o755 || st.Mtim != 54321 {
+		t.Errorf("the root has mode %v and mtime %d", st.Mode, st.Mtim)
+	}
+	if st, _ := m2.Stat("d/e"); st.Mode != fs.ModeDir|0o555 {
+		t.Errorf("d/e has mode %v", st.Mode)
+	}
+	if target, _ := m2.Readlink("s"); target != "d/f" {
+		t.Errorf("s points to %q", target)
+	}
+	if got := readString(t, m2, "d/f"); got != "content" {
+		t.Errorf("d/f is %q", got)
+	}
+}
+
+func TestTarReproducible(t *testing.T) {
+	m := newTarTree(t)
+	archive := writeTar(t, m)
+	readString(t, m, "h")
+	if _, errno := m.readDir("d"); errno != 0 {
+		t.Fatal(errno)
+	}
+	if !bytes.Equal(writeTar(t, m), archive) {
+		t.Error("reading files changed the archive")
+	}
+}
@@ -0,0 +1,151 @@
+package memfs
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	wasys "github.com/tetratelabs/wazero/sys"
+
+	"github.com/tetratelabs/wazero/experimental/sys"
+)
+
+// SkipDir and SkipAll can be returned by a WalkFunc or WalkDirFunc, as
+// fs.SkipDir and fs.SkipAll: SkipDir skips the directory it was called on,
+// or the rest of the containing directory if called on a file, and SkipAll
+// stops the walk. Neither is returned by Walk or WalkDir. Their values are
+// outside of those used by wazero.
+const (
+	SkipDir sys.Errno = 0xffff - iota
+	SkipAll
+)
+
+// WalkDirFunc is called by WalkDir for each file or directory. errno is set
+// if path cannot be read: for the root, d is then zero; for a directory,
+// which it was already called for, the entries cannot be listed. Returning a
+// non-zero Errno other than SkipDir and SkipAll stops the walk with it.
+type WalkDirFunc func(path string, d sys.Dirent, errno sys.Errno) sys.Errno
+
+// WalkFunc is called by Walk for each file or directory, with its Lstat;
+// errno is as in WalkDirFunc.
+type WalkFunc func(path string, st wasys.Stat_t, errno sys.Errno) sys.Errno
+
+// WalkDir walks the tree rooted at root, calling fn for each file and
+// directory, root included, as fs.WalkDir: directories are called before
+// their entries, which are walked in lexical order. Paths are root joined
+// with the names, so they are relative if root is. Symlinks are never
+// followed, even root.
+//
+// The tree is walked as it is at the time each directory is read, so changes
+// made meanwhile may or may not be seen.
+func (m *MemFS) WalkDir(root string, fn WalkDirFunc) sys.Errno {
+	st, errno := m.Lstat(root)
+	if errno != 0 {
+		errno = fn(root, sys.Dirent{}, errno)
+	} else {
+		d := sys.Dirent{Name: path.Base(root), Ino: st.Ino, Type: st.Mode.Type()}
+		errno = m.walkDir(root, d, fn)
+	}
+	if errno == SkipDir || errno == SkipAll {
+		return 0
+	}
+	return errno
+}
+
+// walkDir walks p, whose entry is d.
+func (m *MemFS) walkDir(p string, d sys.Dirent, fn WalkDirFunc) sys.Errno {
+	if errno := fn(p, d, 0); errno != 0 || !d.IsDir() {
+		if errno == SkipDir && d.IsDir() {
+			return 0
+		}
+		return errno
+	}
+
+	dirents, errno := m.readDir(p)
+	if errno != 0 {
+		if errno = fn(p, d, errno); errno == SkipDir {
+			return 0
+		}
+		return errno
+	}
+	for _, dirent := range dirents {
+		if errno = m.walkDir(joinPath(p, dirent.Name), dirent, fn); errno != 0 {
+			if errno == SkipDir {
+				// returned for a file
+				return 0
+			}
+			return errno
+		}
+	}
+	return 0
+}
+
+// Walk walks the tree rooted at root as WalkDir, calling fn with the Lstat
+// of each file and directory, as filepath.Walk. A file removed between being
+// listed and its Lstat is called with ENOENT.
+func (m *MemFS) Walk(root string, fn WalkFunc) sys.Errno {
+	return m.WalkDir(root, func(p string, d sys.Dirent, errno sys.Errno) sys.Errno {
+		var st wasys.Stat_t
+		if errno == 0 {
+			st, errno = m.Lstat(p)
+		}
+		return fn(p, st, errno)
+	})
+}
+
+// Tree returns a listing of the whole tree of m drawn as by the tree command,
+// for debugging and test failures. Each line holds a name, with a slash for
+// directories and the target for symlinks, then the mode, the size of
+// regular files and the number of links of hard linked ones. As in WriteTar,
+// the tree is listed at once, so changes made meanwhile are not seen.
+func (m *MemFS) Tree() string {
+	entries := m.list()[1:]
+
+	// last[i] is set if entries[i] is the last one of its directory.
+	last := make([]bool, len(entries))
+	var later []bool
+	for i := len(entries) - 1; i >= 0; i-- {
+		d := entries[i].depth
+		for len(later) <= d {
+			later = append(later, false)
+		}
+		last[i] = !later[d]
+		later[d] = true
+		for j := d + 1; j < len(later); j++ {
+			later[j] = false
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("/\n")
+	indents := []string{"", ""}
+	for i, e := range entries {
+		branch, more := "├── ", "│   "
+		if last[i] {
+			branch, more = "└── ", "    "
+		}
+		indent := indents[e.depth]
+		b.WriteString(indent + branch + path.Base(e.path))
+
+		st := e.st
+		switch {
+		case e.n.isDir():
+			fmt.Fprintf(&b, "/ (%v)\n", st.Mode)
+			indents = append(indents[:e.depth+1], indent+more)
+			continue
+		case st.Mode&fs.ModeSymlink != 0:
+			fmt.Fprintf(&b, " -> %s\n", e.n.target)
+			continue
+		}
+		fmt.Fprintf(&b, " (%v", st.Mode)
+		if st.Mode.IsRegular() {
+			fmt.Fprintf(&b, ", %d bytes", st.Size)
+		}
+		if st.Nlink > 1 {
+			fmt.Fprintf(&b, ", %d links", st.Nlink)
+		}
+		b.WriteString(")\n")
+	}
+	return b.String()
+}
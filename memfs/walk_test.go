@@ -0,0 +1,103 @@
+package memfs
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tetratelabs/wazero/experimental/sys"
+)
+
+func newWalkTree(t *testing.T) *MemFS {
+	t.Helper()
+	m := New()
+	for i, errno := range []sys.Errno{
+		m.WriteFile("/a/b/c/f", []byte("xyz"), 0o600),
+		m.MkdirAll("/a/x/y", 0o755),
+		m.Symlink("/a/b", "/a/x/l"),
+		m.Link("/a/b/c/f", "/a/x/h"),
+		m.WriteFile("rel.txt", nil, 0o644),
+	} {
+		if errno != 0 {
+			t.Fatalf("step %d: %v", i, errno)
+		}
+	}
+	return m
+}
+
+func TestWalkDir(t *testing.T) {
+	m := newWalkTree(t)
+	var paths []string
+	errno := m.WalkDir("/a", func(p string, d sys.Dirent, errno sys.Errno) sys.Errno {
+		if errno != 0 {
+			t.Errorf("%s: %v", p, errno)
+		}
+		paths = append(paths, p)
+		if p == "/a/b" {
+			return SkipDir
+		}
+		return 0
+	})
+	want := []string{"/a", "/a/b", "/a/x", "/a/x/h", "/a/x/l", "/a/x/y"}
+	if errno != 0 || !reflect.DeepEqual(paths, want) {
+		t.Errorf("WalkDir(/a) = %v, walked %v, want %v", errno, paths, want)
+	}
+
+	if errno := m.WalkDir("nope", func(p string, d sys.Dirent, errno sys.Errno) sys.Errno {
+		return errno
+	}); errno != sys.ENOENT {
+		t.Errorf("WalkDir(nope) = %v, want ENOENT", errno)
+	}
+}
+
+func TestGlob(t *testing.T) {
+	m := newWalkTree(t)
+	if matches, errno := m.Glob("/a/*/*"); errno != 0 || !reflect.DeepEqual(matches, []string{"/a/b/c", "/a/x/h", "/a/x/l", "/a/x/y"}) {
+		t.Errorf("Glob(/a/*/*) = %v, %v", matches, errno)
+	}
+	if _, errno := m.Glob("a/["); errno != sys.EINVAL {
+		t.Errorf("Glob(a/[) = %v, want EINVAL", errno)
+	}
+}
+
+func TestRemoveAll(t *testing.T) {
+	m := newWalkTree(t)
+	if errno := m.RemoveAll("/a/x"); errno != 0 {
+		t.Fatal(errno)
+	}
+	if ok, _ := m.Exists("/a/b/c/f"); !ok {
+		t.Error("the target of the removed links is gone")
+	}
+	if ok, errno := m.Exists("/a/b/c/f/g"); ok || errno != 0 {
+		t.Errorf("Exists(/a/b/c/f/g) = %v, %v", ok, errno)
+	}
+	if errno := m.RemoveAll("/"); errno != 0 {
+		t.Fatal(errno)
+	}
+	if u := m.Usage(); u.Inodes != 1 || u.Bytes != 0 {
+		t.Errorf("usage of the emptied tree is %+v", u)
+	}
+	checkFS(t, m)
+}
+
+func TestTree(t *testing.T) {
+	m := newWalkTree(t)
+	// parents made by WriteFile have mode 0o777
+	c := m.Clone()
+	want := `/
+├── a/ (drwxrwxrwx)
+│   ├── b/ (drwxrwxrwx)
+│   │   └── c/ (drwxrwxrwx)
+│   │       └── f (-rw-------, 3 bytes, 2 links)
+│   └── x/ (drwxr-xr-x)
+│       ├── h (-rw-------, 3 bytes, 2 links)
+│       ├── l -> /a/b
+│       └── y/ (drwxr-xr-x)
+└── rel.txt (-rw-r--r--, 0 bytes)
+`
+	if got := c.Tree(); got != want {
+		t.Errorf("Tree() =\n%s\nwant\n%s", got, want)
+	}
+	if c.top.below != m.top.below {
+		t.Error("Tree froze the layer of the clone")
+	}
+}
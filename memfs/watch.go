@@ -0,0 +1,198 @@
+package memfs
+
+import (
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	wasys "github.com/tetratelabs/wazero/sys"
+)
+
+// EventKind is the kind of change an Event reports.
+type EventKind uint8
+
+const (
+	// Overflow reports that events were dropped as the buffer of the Watcher
+	// was full; its Path and Ino are zero.
+	Overflow EventKind = iota
+	// Create reports a new file, symlink, hard link, device or FIFO.
+	Create
+	// Write reports a successful Write or Pwrite.
+	Write
+	// Truncate reports a Truncate, or an open with O_TRUNC.
+	Truncate
+	// Rename reports a Rename; Path is the new path and OldPath the old one.
+	Rename
+	// Unlink reports a removed file, or a hard link of it.
+	Unlink
+	// Mkdir reports a new directory.
+	Mkdir
+	// Rmdir reports a removed directory.
+	Rmdir
+	// Chmod reports a change of the mode bits.
+	Chmod
+	// CloseWrite reports the close of a file opened for writing, like
+	// IN_CLOSE_WRITE of inotify, for picking up output once complete.
+	CloseWrite
+)
+
+var eventKindNames = [...]string{"overflow", "create", "write", "truncate", "rename", "unlink", "mkdir", "rmdir", "chmod", "close-write"}
+
+func (k EventKind) String() string {
+	if int(k) < len(eventKindNames) {
+		return eventKindNames[k]
+	}
+	return "unknown"
+}
+
+// Event is a change of a MemFS, as reported to a Watcher.
+type Event struct {
+	Kind EventKind
+	// Path is the path the change was made through, cleaned and absolute;
+	// a file changed through a symlink or another hard link is reported
+	// under that path, and Write, Truncate and CloseWrite of an opened file
+	// under the path it was opened with.
+	Path string
+	// OldPath is set on Rename only.
+	OldPath string
+	Ino     wasys.Inode
+}
+
+// Watcher receives the events of a MemFS under a path prefix; see Watch.
+type Watcher struct {
+	m      *MemFS
+	prefix string
+	events chan Event
+	// buffer is the capacity of events for regular events; the one slot
+	// left is for an Overflow.
+	buffer int
+
+	// mu guards sending to events, so that Close never closes it during
+	// a send.
+	mu         sync.Mutex
+	closed     bool
+	overflowed bool
+	dropped    atomic.Uint64
+}
+
+// Watch reports the changes of the files under prefix, the root for "/" or
+// "", on the channel returned by Events, until Close. Events are reported
+// once the change is made, in the order of the changes of each file; a
+// change of the tree made by several operations, such as WriteFile, is
+// reported as each of them.
+//
+// The channel holds up to buffer events (at least 1). Changes are never
+// delayed by a slow receiver: once the channel is full, events are dropped,
+// and an Overflow event is queued after the last one kept; see Dropped.
+//
+// Watchers are not kept by Clone.
+func (m *MemFS) Watch(prefix string, buffer int) *Watcher {
+	buffer = max(buffer, 1)
+	w := &Watcher{
+		m:      m,
+		prefix: path.Join("/", prefix),
+		events: make(chan Event, buffer+1),
+		buffer: buffer,
+	}
+	m.watchMu.Lock()
+	m.watchers = append(m.watchers, w)
+	m.watchMu.Unlock()
+	return w
+}
+
+// WatchFunc is Watch calling fn with each event, from a goroutine of its own,
+// until Close.
+func (m *MemFS) WatchFunc(prefix string, buffer int, fn func(Event)) *Watcher {
+	w := m.Watch(prefix, buffer)
+	go func() {
+		for e := range w.events {
+			fn(e)
+		}
+	}()
+	return w
+}
+
+// Events returns the channel of the events, closed by Close. It must not be
+// read if the Watcher was returned by WatchFunc.
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+// Dropped returns the number of events dropped so far as the buffer was full.
+func (w *Watcher) Dropped() uint64 {
+	return w.dropped.Load()
+}
+
+// Close stops reporting events and closes the channel; the events already
+// in it can still be received.
+func (w *Watcher) Close() {
+	m := w.m
+	m.watchMu.Lock()
+	for i, x := range m.watchers {
+		if x == w {
+			m.watchers = append(m.watchers[:i:i], m.watchers[i+1:]...)
+			break
+		}
+	}
+	m.watchMu.Unlock()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.closed {
+		w.closed = true
+		close(w.events)
+	}
+}
+
+// watches reports whether e is under the prefix of w.
+func (w *Watcher) watches(e *Event) bool {
+	under := func(p string) bool {
+		return p != "" && (w.prefix == "/" || p == w.prefix || strings.HasPrefix(p, w.prefix+"/"))
+	}
+	return under(e.Path) || under(e.OldPath)
+}
+
+// send queues e, unless the channel is full.
+func (w *Watcher) send(e Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	switch {
+	case w.closed:
+	case len(w.events) < w.buffer:
+		w.events <- e
+		w.overflowed = false
+	default:
+		if !w.overflowed {
+			// the slot left
+			w.events <- Event{Kind: Overflow}
+			w.overflowed = true
+		}
+		w.dropped.Add(1)
+	}
+}
+
+// notify reports a change of kind to the watchers of m. p is the path given
+// to the operation.
+func (m *MemFS) notify(kind EventKind, p string, ino wasys.Inode) {
+	m.notifyRename(kind, p, "", ino)
+}
+
+// notifyRename is notify with the old path of a Rename.
+func (m *MemFS) notifyRename(kind EventKind, p, oldPath string, ino wasys.Inode) {
+	m.watchMu.RLock()
+	defer m.watchMu.RUnlock()
+	if len(m.watchers) == 0 {
+		return
+	}
+
+	e := Event{Kind: kind, Path: path.Join("/", p), Ino: ino}
+	if oldPath != "" {
+		e.OldPath = path.Join("/", oldPath)
+	}
+	for _, w := range m.watchers {
+		if w.watches(&e) {
+			w.send(e)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package memfs
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tetratelabs/wazero/experimental/sys"
+)
+
+func TestWatch(t *testing.T) {
+	m := New()
+	w := m.Watch("/a", 100)
+	first := make(chan Event, 1)
+	all := m.WatchFunc("", 2, func(e Event) {
+		select {
+		case first <- e:
+		default:
+		}
+	})
+	for i, errno := range []sys.Errno{
+		m.Mkdir("a", 0o755),
+		m.WriteFile("a/f", []byte("x"), 0o644),
+		m.Chmod("a/f", 0o600),
+		m.Rename("a/f", "b"),
+		m.Rename("b", "a/g"),
+		m.Symlink("g", "a/s"),
+		m.Link("a/g", "a/h"),
+		m.Unlink("a/h"),
+		// not under /a
+		m.Mkdir("ab", 0o755),
+		m.Rmdir("ab"),
+		m.Mkdir("a/d", 0o755),
+		m.Rmdir("a/d"),
+	} {
+		if errno != 0 {
+			t.Fatalf("step %d: %v", i, errno)
+		}
+	}
+	f, errno := m.OpenFile("a/g", sys.O_RDWR|sys.O_TRUNC, 0)
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	f.Truncate(3)
+	f.Close()
+	w.Close()
+	all.Close()
+
+	var got []string
+	for e := range w.Events() {
+		got = append(got, fmt.Sprintf("%v %s %s", e.Kind, e.Path, e.OldPath))
+	}
+	want := []string{
+		"mkdir /a ",
+		"create /a/f ",
+		"write /a/f ",
+		"close-write /a/f ",
+		"chmod /a/f ",
+		"rename /b /a/f",
+		"rename /a/g /b",
+		"create /a/s ",
+		"create /a/h ",
+		"unlink /a/h ",
+		"mkdir /a/d ",
+		"rmdir /a/d ",
+		"truncate /a/g ",
+		"truncate /a/g ",
+		"close-write /a/g ",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events:\n%q\nwant\n%q", got, want)
+	}
+	if w.Dropped() != 0 {
+		t.Errorf("%d events dropped", w.Dropped())
+	}
+	// still called with the events queued before Close
+	select {
+	case e := <-first:
+		if e.Kind != Mkdir || e.Path != "/a" {
+			t.Errorf("WatchFunc called first with %+v", e)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("the function of WatchFunc was never called")
+	}
+}
+
+func TestWatchOverflow(t *testing.T) {
+	m := New()
+	w := m.Watch("", 2)
+	for i := 0; i < 10; i++ {
+		if errno := m.Mkdir(fmt.Sprint("d", i), 0o755); errno != 0 {
+			t.Fatal(errno)
+		}
+	}
+	w.Close()
+	var kinds []EventKind
+	for e := range w.Events() {
+		kinds = append(kinds, e.Kind)
+	}
+	// the Overflow takes the slot left after buffer events
+	if !reflect.DeepEqual(kinds, []EventKind{Mkdir, Mkdir, Overflow}) || w.Dropped() != 8 {
+		t.Errorf("events %v, with %d dropped", kinds, w.Dropped())
+	}
+}